@@ -0,0 +1,136 @@
+package pulse
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/antlabs/pulse/core"
+)
+
+// accept出错之后的退避时间, 每次翻倍, accept成功之后重置
+const (
+	minAcceptDelay = 5 * time.Millisecond
+	maxAcceptDelay = time.Second
+)
+
+// accept的统计信息
+type AcceptStats struct {
+	Errors   uint64 // accept失败的次数, 不包括EAGAIN/EINTR/ECONNABORTED
+	Shed     uint64 // fd耗尽(EMFILE/ENFILE)的时候直接关闭的连接数
+	Rejected uint64 // 超过连接数限制或者被OnAccept拒绝的连接数
+}
+
+// AcceptStats 返回accept的统计信息
+func (e *MultiEventLoop) AcceptStats() AcceptStats {
+	return AcceptStats{
+		Errors:   e.acceptErrors.Load(),
+		Shed:     e.acceptShed.Load(),
+		Rejected: e.acceptRejected.Load(),
+	}
+}
+
+func nextAcceptDelay(d time.Duration) time.Duration {
+	if d == 0 {
+		return minAcceptDelay
+	}
+	d *= 2
+	if d > maxAcceptDelay {
+		d = maxAcceptDelay
+	}
+	return d
+}
+
+// 进程或者系统的fd用完了
+func isFdExhausted(err error) bool {
+	return errors.Is(err, core.EMFILE) || errors.Is(err, core.ENFILE)
+}
+
+// acceptError 记录accept错误, 然后通知用户
+func (e *MultiEventLoop) acceptError(laddr net.Addr, err error) {
+	e.acceptErrors.Add(1)
+	slog.Error("accept", "addr", laddr, "err", err)
+	if e.options.onAcceptError != nil {
+		e.options.onAcceptError(laddr, err)
+	}
+}
+
+// shedListener 用预留的fd把一个排队的连接accept出来直接关闭
+// 没有排队的连接的时候不会等待
+func (e *MultiEventLoop) shedListener(l net.Listener) (shed bool) {
+	sc, ok := l.(syscall.Conn)
+	if !ok {
+		return false
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return false
+	}
+	// 监听socket已经是非阻塞的, 直接在fd上accept
+	if err := rc.Control(func(fd uintptr) {
+		shed = e.shedFd(int(fd))
+	}); err != nil {
+		return false
+	}
+	return shed
+}
+
+// shedLoopListener 同shedListener, 在event loop里使用
+func (e *MultiEventLoop) shedLoopListener(l *loopListener) bool {
+	return e.shedFd(l.fd)
+}
+
+func (e *MultiEventLoop) shedFd(listenFd int) (shed bool) {
+	e.reserveFd.Shed(func() {
+		fd, _, err := core.AcceptNonblock(listenFd)
+		if err != nil {
+			return
+		}
+		_ = core.Close(fd)
+		shed = true
+	})
+	if shed {
+		e.acceptShed.Add(1)
+	}
+	return shed
+}
+
+// admit 连接数限制和准入回调, 通过之后返回连接关闭时释放名额用的key
+func (e *MultiEventLoop) admit(remoteAddr net.Addr) (key string, ok bool) {
+	key, ok = e.limiter.acquire(remoteAddr)
+	if !ok {
+		return "", false
+	}
+	if e.options.onAccept != nil && !e.options.onAccept(remoteAddr) {
+		e.limiter.release(key)
+		return "", false
+	}
+	return key, true
+}
+
+// rejectFd 拒绝连接, 写一次拒绝响应之后关闭
+func (e *MultiEventLoop) rejectFd(fd int) {
+	e.acceptRejected.Add(1)
+	if len(e.options.rejectResponse) > 0 {
+		// fd是非阻塞的, 写不进去就算了
+		_, _ = core.Write(fd, e.options.rejectResponse)
+	}
+	_ = core.Close(fd)
+}
+
+// rejectConn 同rejectFd, accept协程里使用
+func (e *MultiEventLoop) rejectConn(c net.Conn) {
+	e.acceptRejected.Add(1)
+	if len(e.options.rejectResponse) > 0 {
+		if sc, ok := c.(syscall.Conn); ok {
+			if rc, err := sc.SyscallConn(); err == nil {
+				_ = rc.Control(func(fd uintptr) {
+					_, _ = core.Write(int(fd), e.options.rejectResponse)
+				})
+			}
+		}
+	}
+	_ = c.Close()
+}
@@ -0,0 +1,144 @@
+//go:build linux
+
+package pulse
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNextAcceptDelay(t *testing.T) {
+	var d time.Duration
+	want := []time.Duration{5 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond}
+	for _, w := range want {
+		d = nextAcceptDelay(d)
+		if d != w {
+			t.Fatalf("nextAcceptDelay() = %v, want %v", d, w)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		d = nextAcceptDelay(d)
+	}
+	if d != maxAcceptDelay {
+		t.Errorf("nextAcceptDelay() = %v, want %v", d, maxAcceptDelay)
+	}
+}
+
+// exhaustFds 降低fd上限并占满, 只留下keep个空闲的fd, 返回恢复函数
+func exhaustFds(t *testing.T, keep int) (restore func()) {
+	var old syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &old); err != nil {
+		t.Fatalf("Getrlimit() error = %v", err)
+	}
+
+	var dummies []int
+	restore = func() {
+		for _, fd := range dummies {
+			_ = syscall.Close(fd)
+		}
+		dummies = nil
+		_ = syscall.Setrlimit(syscall.RLIMIT_NOFILE, &old)
+	}
+
+	// 当前最大的fd之后留一点空间
+	fd, err := syscall.Open("/dev/null", syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	_ = syscall.Close(fd)
+	limit := old
+	limit.Cur = uint64(fd) + 256
+	if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		t.Skipf("Setrlimit() error = %v", err)
+	}
+
+	for {
+		fd, err := syscall.Open("/dev/null", syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+		if err != nil {
+			if !errors.Is(err, syscall.EMFILE) {
+				restore()
+				t.Fatalf("Open() error = %v", err)
+			}
+			break
+		}
+		dummies = append(dummies, fd)
+	}
+	for i := 0; i < keep && len(dummies) > 0; i++ {
+		_ = syscall.Close(dummies[len(dummies)-1])
+		dummies = dummies[:len(dummies)-1]
+	}
+	return restore
+}
+
+func TestMultiEventLoop_AcceptEMFILE(t *testing.T) {
+	for _, reusePort := range []bool{false, true} {
+		var hookCalls atomic.Int64
+		el, err := NewMultiEventLoop(context.Background(),
+			WithCallback(echoCallback{}),
+			WithTaskType(TaskTypeInEventLoop),
+			WithEventLoopCount(1),
+			WithReusePort(reusePort),
+			WithOnAcceptError(func(laddr net.Addr, err error) {
+				if isFdExhausted(err) {
+					hookCalls.Add(1)
+				}
+			}))
+		if err != nil {
+			t.Fatalf("NewMultiEventLoop() error = %v", err)
+		}
+
+		addr, err := el.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Listen() error = %v", err)
+		}
+		// 确认服务端已经正常工作
+		c := dialRetry(t, "tcp", addr.String())
+		echoRoundTrip(t, c, "hello")
+		c.Close()
+		// 等服务端关闭连接, 不然占满fd之后服务端又释放出来一个
+		for i := 0; i < 100 && el.eventLoops[0].connCount.Load() != 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		// 只给客户端留一个fd, 服务端accept的时候会EMFILE
+		restore := exhaustFds(t, 1)
+		c, err = net.Dial("tcp", addr.String())
+		if err != nil {
+			restore()
+			t.Fatalf("Dial() error = %v", err)
+		}
+
+		// 连接会被丢弃, 客户端应该很快读到EOF, 而不是一直等待
+		_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, readErr := c.Read(make([]byte, 1))
+		c.Close()
+		restore()
+		if !errors.Is(readErr, io.EOF) && !errors.Is(readErr, syscall.ECONNRESET) {
+			t.Errorf("reusePort=%v: Read() error = %v, want EOF", reusePort, readErr)
+		}
+
+		stats := el.AcceptStats()
+		if stats.Errors == 0 || stats.Shed == 0 {
+			t.Errorf("reusePort=%v: AcceptStats() = %+v, want errors and shed", reusePort, stats)
+		}
+		if hookCalls.Load() == 0 {
+			t.Errorf("reusePort=%v: OnAcceptError not called", reusePort)
+		}
+
+		// fd恢复之后可以正常accept
+		c = dialRetry(t, "tcp", addr.String())
+		echoRoundTrip(t, c, "hello again")
+		c.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		_ = el.Shutdown(ctx)
+		cancel()
+		el.Free()
+	}
+}
@@ -3,16 +3,15 @@ package pulse
 import (
 	"context"
 	"fmt"
+	"io"
 	"net"
 	"sync"
-	"sync/atomic"
 
 	"github.com/antlabs/pulse/core"
 )
 
 type ClientEventLoop struct {
 	*MultiEventLoop
-	next     uint32                // 轮询计数器
 	conns    *core.SafeConns[Conn] // 每个事件循环的连接管理器
 	callback Callback              // 回调函数
 	ctx      context.Context       // 上下文
@@ -48,32 +47,40 @@ func (loop *ClientEventLoop) RegisterConn(conn net.Conn) error {
 		return fmt.Errorf("failed to close original connection: %w", err)
 	}
 
-	// 3. 选择事件循环（轮询分配）
-	eventLoopIndex := loop.selectEventLoop()
+	// 3. 选择事件循环（按负载均衡策略分配）
+	eventLoopIndex := loop.selectEventLoop(conn.RemoteAddr())
 	eventLoop := loop.MultiEventLoop.eventLoops[eventLoopIndex]
 
 	// 4. 创建新连接
 	connInstance := loop.createConn(fd, loop.conns, eventLoop)
+	connInstance.localAddr = conn.LocalAddr()
+	connInstance.remoteAddr = conn.RemoteAddr()
+	connInstance.callback = loop.callback
+	connInstance.options = &loop.MultiEventLoop.options
+	connInstance.adapter = newAdapter(loop.callback)
+	connInstance.startIdleTimer(loop.MultiEventLoop.options.idleTimeout)
+	applySockopts(fd, loop.MultiEventLoop.options.sockopts)
 
 	// 5. 添加到连接管理器
 	loop.conns.Add(fd, connInstance)
+	eventLoop.connCount.Add(1)
 
 	// 6. 调用回调函数
 	if loop.callback != nil {
 		loop.callback.OnOpen(connInstance)
 	}
 
-	// 7. 添加到事件循环
+	// 7. 添加到事件循环, OnOpen里已经关闭了连接的时候不再注册
+	connInstance.mu.Lock()
+	defer connInstance.mu.Unlock()
+	if connInstance.getFd() == -1 {
+		return nil
+	}
 	return eventLoop.AddRead(fd)
 }
 
-// selectEventLoop 选择事件循环（轮询分配）
-func (loop *ClientEventLoop) selectEventLoop() int {
-	return int(atomic.AddUint32(&loop.next, 1) % uint32(len(loop.MultiEventLoop.eventLoops)))
-}
-
 // createConn 创建连接实例
-func (loop *ClientEventLoop) createConn(fd int, safeConns *core.SafeConns[Conn], eventLoop core.PollingApi) *Conn {
+func (loop *ClientEventLoop) createConn(fd int, safeConns *core.SafeConns[Conn], eventLoop *eventLoop) *Conn {
 	return newConn(
 		fd,
 		safeConns,
@@ -94,6 +101,9 @@ func (loop *ClientEventLoop) Serve() {
 	for i := 0; i < n; i++ {
 		go func(idx int) {
 			defer wg.Done()
+			defer loop.MultiEventLoop.eventLoops[idx].stop()
+			loop.MultiEventLoop.eventLoops[idx].run()
+			loop.MultiEventLoop.lockThread(loop.MultiEventLoop.eventLoops[idx])
 			buf := make([]byte, loop.MultiEventLoop.options.eventLoopReadBufferSize)
 			for {
 				select {
@@ -101,46 +111,57 @@ func (loop *ClientEventLoop) Serve() {
 					return
 				default:
 				}
-				_, err := loop.MultiEventLoop.eventLoops[idx].Poll(0, func(fd int, state core.State, pollErr error) {
-					c := loop.conns.GetUnsafe(fd)
+				timeout := loop.MultiEventLoop.eventLoops[idx].pollTimeout()
+				loop.MultiEventLoop.eventLoops[idx].setBusy(false)
+				_, err := loop.MultiEventLoop.eventLoops[idx].Poll(timeout, func(fd int, state core.State, pollErr error) {
+					loop.MultiEventLoop.eventLoops[idx].setBusy(true)
+					c := loop.conns.Get(fd)
 					if pollErr != nil {
 						if c != nil {
-							c.Close()
-							if loop.callback != nil {
-								loop.callback.OnClose(c, pollErr)
-							}
+							c.closeWithError(pollErr)
 						}
 						return
 					}
 					if c == nil {
 						return
 					}
-					if state.IsRead() {
+					if state.IsWrite() && c.needFlush() {
+						c.flush()
+					}
+					// 对端关闭了写端的时候一直读到EOF, 不然边缘触发模式下不会再有事件
+					for state.IsRead() {
 						c.mu.Lock()
+						if c.closing || c.readPaused {
+							c.mu.Unlock()
+							return
+						}
 						n, err := core.Read(fd, buf)
+						if n > 0 {
+							c.markRead()
+						}
 						c.mu.Unlock()
 						if err != nil {
-							c.Close()
-							if loop.callback != nil {
-								loop.callback.OnClose(c, err)
-							}
+							c.closeWithError(err)
 							return
 						}
 						if n == 0 {
-							c.Close()
-							if loop.callback != nil {
-								loop.callback.OnClose(c, nil)
-							}
+							c.closeWithError(io.EOF)
 							return
 						}
 						if loop.callback != nil {
 							loop.callback.OnData(c, buf[:n])
 						}
+						if !state.IsRDHUP() {
+							break
+						}
 					}
 				})
+				loop.MultiEventLoop.eventLoops[idx].setBusy(true)
 				if err != nil {
 					break
 				}
+				loop.MultiEventLoop.eventLoops[idx].runTasks()
+				loop.MultiEventLoop.eventLoops[idx].runTimers()
 			}
 		}(i)
 	}
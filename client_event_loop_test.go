@@ -71,8 +71,8 @@ func TestClientEventLoop_SelectEventLoop(t *testing.T) {
 	loop := NewClientEventLoop(ctx, WithCallback(&testCallback{}))
 
 	// 测试事件循环选择
-	index1 := loop.selectEventLoop()
-	index2 := loop.selectEventLoop()
+	index1 := loop.selectEventLoop(nil)
+	index2 := loop.selectEventLoop(nil)
 
 	if index1 < 0 || index1 >= len(loop.MultiEventLoop.eventLoops) {
 		t.Errorf("Invalid event loop index: %d", index1)
@@ -85,3 +85,33 @@ func TestClientEventLoop_SelectEventLoop(t *testing.T) {
 	// 验证轮询分配（虽然不能保证每次都不同，但应该合理分布）
 	t.Logf("Selected event loops: %d, %d", index1, index2)
 }
+
+func TestClientEventLoop_RegisterConnAddr(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() error = %v", err)
+	}
+	defer l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opened := make(chan *Conn, 1)
+	loop := NewClientEventLoop(ctx, WithCallback(ToCallback(func(c *Conn, err error) {
+		opened <- c
+	}, func(c *Conn, data []byte) {}, func(c *Conn, err error) {})))
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	laddr, raddr := conn.LocalAddr(), conn.RemoteAddr()
+	if err := loop.RegisterConn(conn); err != nil {
+		t.Fatalf("RegisterConn() error = %v", err)
+	}
+
+	c := <-opened
+	defer c.Close()
+	if c.LocalAddr() != laddr || c.RemoteAddr() != raddr {
+		t.Errorf("addr = %v -> %v, want %v -> %v", c.LocalAddr(), c.RemoteAddr(), laddr, raddr)
+	}
+}
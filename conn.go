@@ -2,8 +2,11 @@ package pulse
 
 import (
 	"errors"
+	"io"
 	"log/slog"
+	"math"
 	"net"
+	"os"
 	"sync"
 	"sync/atomic"
 	"syscall"
@@ -13,34 +16,105 @@ import (
 	"github.com/antlabs/task/task/driver"
 )
 
+var (
+	// ErrIdleTimeout 连接超过WithIdleTimeout设置的时间没有读写, 被关闭的时候传给OnClose
+	ErrIdleTimeout = errors.New("pulse: idle timeout")
+	// ErrWriteClosed 调用CloseWrite之后再写数据
+	ErrWriteClosed = errors.New("pulse: write side closed")
+	// ErrWriteBufferFull 写缓冲区超过了WithMaxPendingWriteBytes设置的上限
+	ErrWriteBufferFull = errors.New("pulse: write buffer full")
+)
+
 type Conn struct {
 	fd         int64
 	wbufList   []*[]byte // write buffer, 为了理精细控制内存使用量
 	mu         sync.Mutex
 	safeConns  *core.SafeConns[Conn]
 	task       driver.TaskExecutor
-	eventLoop  core.PollingApi
-	readTimer  *time.Timer
-	writeTimer *time.Timer
-	session    any // 会话数据
+	eventLoop  *eventLoop
+	readTimer  *Timer
+	writeTimer *Timer
+	session    any             // 会话数据
+	callback   Callback        // 连接使用的回调, listener单独设置了回调的时候是listener的回调
+	limitKey   string          // 单个ip连接数限制的key
+	limiter    *connLimiter    // 经过admit占用名额的连接才设置, 关闭的时候释放名额
+	localAddr  net.Addr        // 本地地址, 创建连接的时候获取, 之后不会修改
+	remoteAddr net.Addr        // 对端地址, 创建连接的时候获取, 之后不会修改
+	adapter    *netConnAdapter // 阻塞读写适配器, 当成net.Conn使用的时候才有
+
+	// 读超时, 每次读到数据之后readDeadline = now + readTimeout
+	readDeadline  time.Time
+	readTimeout   time.Duration
+	writeDeadline time.Time
+
+	// 空闲超时, 读到数据或者写入数据都会更新lastActive
+	idleTimeout time.Duration
+	idleTimer   *Timer
+	lastActive  time.Time
+
+	// 半关闭
+	readEOF    bool // 对端关闭了写端, 开启WithOnReadEOF的时候不关闭连接, 之后不再读
+	closeWrite bool // 调用了CloseWrite, 写缓冲区发送完之后shutdown(SHUT_WR)
+	writeShut  bool // 已经shutdown(SHUT_WR)
+
+	closing    bool   // 调用了CloseAfterFlush, 不再读, 写缓冲区发送完之后关闭
+	closeTimer *Timer // CloseAfterFlush的超时
+
+	// closeNoLock记下关闭的原因, 解锁(c.unlock)的时候回调OnClose, 只会回调一次
+	closeErr    error
+	closeNotify bool
+
+	// 写缓冲区水位, 见WithWriteBufferWatermarks
+	options        *Options // 创建连接的时候设置, 直接构造的Conn为nil
+	buffered       int      // 写缓冲区里的字节数
+	bufferFull     bool     // 超过高水位之后为true, 降到低水位之后为false
+	notifyFull     bool     // 解锁的时候回调OnBufferFull
+	notifyWritable bool     // 解锁的时候回调OnWritable
+
+	// PendingWriteBlock策略下等待写缓冲区降下来的Write
+	pendingCond    *sync.Cond
+	pendingWaiters int
+	blockedWriter  bool // 有Write在分段等待, 其他Write排在它后面
+
+	sendFiles []*pendingFile // SendFile还没发送完的文件, 和wbufList按调用顺序交替发送
 
 	// 如果再加字段，可以改成对options的指针的访问, 目前只是浪费了8个字节
 	readBufferSize             int  // 读缓冲区大小
 	flowBackPressureRemoveRead bool // 流量背压机制，当连接的写缓冲区满了，会移除读事件
-	readableButNotRead         bool // 垂直触发模式下,表示可读未读取的标记位
+	rearmRead                  bool // 改过读写事件或者背压暂停过读, 写缓冲区清空之后需要ResetRead
+	readPaused                 bool // 阻塞适配器的读缓冲区满了, 暂停读, Read读完之后恢复
+}
+
+// LocalAddr 返回本地地址, 连接关闭之后依然可以使用
+func (c *Conn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+// RemoteAddr 返回对端地址, 连接关闭之后依然可以使用
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remoteAddr
 }
 
 func (c *Conn) SetNoDelay(nodelay bool) error {
 	return core.SetNoDelay(c.getFd(), nodelay)
 }
 
+// Execute 把fn放到连接所属的event loop协程里执行
+// 适合在业务协程里操作event loop持有的状态
+func (c *Conn) Execute(fn func()) error {
+	if atomic.LoadInt64(&c.fd) == -1 {
+		return net.ErrClosed
+	}
+	return c.eventLoop.post(fn)
+}
+
 func (c *Conn) getFd() int {
 	return int(atomic.LoadInt64(&c.fd))
 }
 
 func newConn(fd int, safeConns *core.SafeConns[Conn],
 	task selectTasks, taskType TaskType,
-	eventLoop core.PollingApi, readBufferSize int, flowBackPressureRemoveRead bool) *Conn {
+	eventLoop *eventLoop, readBufferSize int, flowBackPressureRemoveRead bool) *Conn {
 	var taskExecutor driver.TaskExecutor
 	switch taskType {
 	case TaskTypeInConnectionGoroutine:
@@ -48,7 +122,7 @@ func newConn(fd int, safeConns *core.SafeConns[Conn],
 	case TaskTypeInEventLoop:
 		// 不做任何事情
 	case TaskTypeInBusinessGoroutine:
-		taskExecutor = task.newTask("stream")
+		taskExecutor = task.newTask("elastic")
 	default:
 		panic("invalid task type")
 	}
@@ -63,17 +137,119 @@ func newConn(fd int, safeConns *core.SafeConns[Conn],
 	}
 }
 
-func (c *Conn) Close() {
+// Close 关闭连接, 会回调OnClose, err为nil
+func (c *Conn) Close() error {
+	c.closeWithError(nil)
+	return nil
+}
+
+// CloseWrite 关闭写端(shutdown SHUT_WR), 对端会读到EOF, 还可以继续读对端发来的数据
+// 写缓冲区里还有数据的时候等发送完再关闭, 之后的Write返回ErrWriteClosed
+// 对端也已经关闭了写端的时候(见WithOnReadEOF), 关闭写端之后连接会被关闭, OnClose收到io.EOF
+func (c *Conn) CloseWrite() error {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.closeNoLock()
+	defer c.unlock()
+	if atomic.LoadInt64(&c.fd) == -1 {
+		return net.ErrClosed
+	}
+	if c.closeWrite {
+		return nil
+	}
+	c.closeWrite = true
+	if c.pendingNoLock() {
+		return nil
+	}
+	return c.shutdownWriteNoLock()
 }
 
-func (c *Conn) closeNoLock() {
+// CloseAfterFlush 不再读数据, 等写缓冲区里的数据发送完之后关闭连接, OnClose收到nil
+// 超过timeout还没有发送完的时候直接关闭, 丢弃剩下的数据, OnClose收到os.ErrDeadlineExceeded
+// timeout <= 0一直等待. 之后的Write返回ErrWriteClosed
+func (c *Conn) CloseAfterFlush(timeout time.Duration) error {
+	c.mu.Lock()
+	defer c.unlock()
+	if atomic.LoadInt64(&c.fd) == -1 {
+		return net.ErrClosed
+	}
+	if c.closing {
+		return nil
+	}
+	c.closing = true
+
+	if !c.pendingNoLock() {
+		c.closeNoLock(nil)
+		return nil
+	}
+
+	// 只等可写事件, 写完之后在write里关闭
+	if err := c.eventLoop.SetWriteOnly(c.getFd(), true); err != nil {
+		slog.Error("failed to delete read event", "error", err)
+	}
+	if timeout > 0 {
+		c.closeTimer = newTimer(c.eventLoop, timeout, func() {
+			c.closeWithError(os.ErrDeadlineExceeded)
+		})
+	}
+	return nil
+}
+
+// shutdownWriteNoLock 写缓冲区清空之后关闭写端, 两个方向都关闭了就关闭连接
+func (c *Conn) shutdownWriteNoLock() error {
+	if c.writeShut {
+		return nil
+	}
+	c.writeShut = true
+	err := core.CloseWrite(c.getFd())
+	if c.readEOF {
+		c.closeNoLock(io.EOF)
+	}
+	return err
+}
+
+// closeWithError 关闭连接, err是关闭的原因, 会传给OnClose和阻塞读写适配器的Read
+// 所有关闭连接的路径最后都走到closeNoLock, 第一次关闭的原因有效
+func (c *Conn) closeWithError(err error) {
+	c.mu.Lock()
+	c.closeNoLock(err)
+	c.unlock()
+}
+
+// unlock 释放c.mu, 持有锁的期间连接被关闭了就回调OnClose
+// 先关闭fd再回调, 回调的时候不持有锁, OnClose里可以调用Conn的方法
+// 水位的回调也在这里, 连接已经关闭的时候不再回调
+func (c *Conn) unlock() {
+	notify, err := c.closeNotify, c.closeErr
+	full, writable := c.notifyFull, c.notifyWritable
+	c.closeNotify, c.notifyFull, c.notifyWritable = false, false, false
+	c.mu.Unlock()
+	if notify {
+		if c.callback != nil {
+			c.callback.OnClose(c, err)
+		}
+		return
+	}
+	if full {
+		c.options.onBufferFull(c)
+	}
+	if writable {
+		c.options.onWritable(c)
+	}
+}
+
+// closeNoLock 关闭连接, 需要持有c.mu, 释放锁的时候要用c.unlock
+func (c *Conn) closeNoLock(cause error) {
 	if atomic.LoadInt64(&c.fd) == -1 {
 		return
 	}
 
+	if c.adapter != nil {
+		if cause != nil {
+			c.adapter.closeWithError(cause)
+		} else {
+			c.adapter.closeWithError(net.ErrClosed)
+		}
+	}
+
 	// Stop timers
 	if c.readTimer != nil {
 		c.readTimer.Stop()
@@ -83,10 +259,24 @@ func (c *Conn) closeNoLock() {
 		c.writeTimer.Stop()
 		c.writeTimer = nil
 	}
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+		c.idleTimer = nil
+	}
+	if c.closeTimer != nil {
+		c.closeTimer.Stop()
+		c.closeTimer = nil
+	}
 
 	oldFd := atomic.SwapInt64(&c.fd, -1)
 	if oldFd != -1 {
+		c.closeErr = cause
+		c.closeNotify = true
 		c.safeConns.Del(int(oldFd))
+		if c.eventLoop != nil {
+			c.eventLoop.connCount.Add(-1)
+		}
+		c.limiter.release(c.limitKey)
 
 		if err := core.Close(int(oldFd)); err != nil {
 			// Log the error but don't panic as this is a cleanup function
@@ -99,12 +289,23 @@ func (c *Conn) closeNoLock() {
 		}
 	}
 	c.wbufList = c.wbufList[:0]
+	c.buffered, c.bufferFull = 0, false
+	for _, f := range c.sendFiles {
+		f.close()
+	}
+	c.sendFiles = nil
+	if c.pendingWaiters > 0 {
+		c.pendingCond.Broadcast()
+	}
 }
 
 // writeToSocket 尝试将数据写入 socket，并处理中断与临时错误
 func (c *Conn) writeToSocket(data []byte) (int, error) {
 
 	n, err := core.Write(c.getFd(), data)
+	if n > 0 && c.idleTimeout > 0 {
+		c.lastActive = time.Now()
+	}
 	if err == nil {
 		return n, nil
 	}
@@ -125,6 +326,7 @@ func (c *Conn) appendToWbufList(data []byte) {
 	if len(data) == 0 {
 		return
 	}
+	c.growBuffered(len(data))
 
 	// 如果wbufList为空，直接创建新的缓冲区
 	if len(c.wbufList) == 0 {
@@ -166,22 +368,31 @@ func (c *Conn) appendToWbufList(data []byte) {
 }
 
 // handlePartialWrite 处理部分写入的情况，创建新缓冲区存储剩余数据
-func (c *Conn) handlePartialWrite(data *[]byte, n int, needAppend bool) error {
+func (c *Conn) handlePartialWrite(data []byte, n int) error {
 	if n < 0 {
 		n = 0
 	}
 
 	// 如果已经全部写入，不需要创建新缓冲区
-	if n >= len(*data) {
+	if n >= len(data) {
 		return nil
 	}
 
-	remainingData := (*data)[n:]
-	if needAppend {
-		c.appendToWbufList(remainingData)
-	} else {
-		copy(*data, (*data)[n:])
-		*data = (*data)[:len(*data)-n]
+	if err := c.queueNoLock(data[n:]); err != nil {
+		return err
+	}
+	return c.waitWritable()
+}
+
+// waitWritable 写缓冲区里还有数据, 等可写事件
+func (c *Conn) waitWritable() error {
+	// 对端已经关闭了写端, 正在关闭或者暂停了读, 只等可写事件
+	if c.readEOF || c.closing || c.readPaused {
+		if err := c.eventLoop.SetWriteOnly(c.getFd(), true); err != nil {
+			slog.Error("failed to add write event", "error", err)
+			return err
+		}
+		return nil
 	}
 
 	// 部分写入成功，或者全部失败
@@ -190,96 +401,323 @@ func (c *Conn) handlePartialWrite(data *[]byte, n int, needAppend bool) error {
 		if delErr := c.eventLoop.DelRead(c.getFd()); delErr != nil {
 			slog.Error("failed to delete read event", "error", delErr)
 		}
-	} else {
-		if err := c.eventLoop.AddWrite(c.getFd()); err != nil {
-			slog.Error("failed to add write event", "error", err)
-			return err
-		}
+		c.rearmRead = true
+		return nil
 	}
 
+	if err := c.eventLoop.AddWrite(c.getFd()); err != nil {
+		slog.Error("failed to add write event", "error", err)
+		return err
+	}
+	// 垂直触发模式下读写事件一直都在, 写完之后不需要ResetRead
+	if !c.edgeTriggered() {
+		c.rearmRead = true
+	}
 	return nil
 }
 
-func (c *Conn) Write(data []byte) (int, error) {
+// edgeTriggered 连接所在的event loop是不是垂直触发模式
+func (c *Conn) edgeTriggered() bool {
+	return c.options != nil && c.options.triggerType == core.TriggerTypeEdge
+}
+
+// pauseRead 写缓冲区里还有数据的时候暂停读(背压), 返回true表示不要再读
+// 垂直触发模式下没读完的数据不会再有事件, 写缓冲区清空之后由flushNoLock重新注册读事件
+func (c *Conn) pauseRead() bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	if !c.pendingNoLock() {
+		return false
+	}
+	c.rearmRead = true
+	return true
+}
 
+func (c *Conn) Write(data []byte) (int, error) {
+	if c.adapter == nil {
+		return c.write(data)
+	}
+
+	// 阻塞适配器模式下, 等数据全部写入内核之后再返回
+	if c.adapter.writeTimeout() {
+		return 0, os.ErrDeadlineExceeded
+	}
+	n, err := c.write(data)
+	if err != nil {
+		return n, err
+	}
+	return n, c.adapter.waitFlushed(c)
+}
+
+func (c *Conn) write(data []byte) (int, error) {
+	c.mu.Lock()
+	defer c.unlock()
+
+	// 等待的时候会释放锁, 先排队再检查连接的状态
+	if len(data) > 0 {
+		if err := c.waitWriterNoLock(); err != nil {
+			return 0, err
+		}
+	}
 	if atomic.LoadInt64(&c.fd) == -1 {
 		return 0, net.ErrClosed
 	}
-
-	if len(data) == 0 && len(c.wbufList) == 0 {
+	if (c.closeWrite || c.closing) && len(data) > 0 {
+		return 0, ErrWriteClosed
+	}
+	if len(data) == 0 && !c.pendingNoLock() {
 		return 0, nil
 	}
 
-	if len(c.wbufList) == 0 {
+	if !c.pendingNoLock() {
+		// 先检查再写, 超过上限的时候一个字节都不写, 不会只写了一部分就返回错误
+		if err := c.checkPendingNoLock(len(data)); err != nil {
+			return 0, err
+		}
 		n, err := c.writeToSocket(data)
 		if errors.Is(err, core.EAGAIN) || errors.Is(err, core.EINTR) || err == nil {
 			if n == len(data) {
 				return n, nil
 			}
 			// 把剩余数据放到缓冲区
-			if err := c.handlePartialWrite(&data, n, true); err != nil {
-				c.closeNoLock()
+			if err := c.handlePartialWrite(data, n); err != nil {
+				c.closeNoLock(err)
 				return 0, err
 			}
 			return len(data), nil
 		}
 
 		// 发生严重错误
-		c.closeNoLock()
+		c.closeNoLock(err)
 		return n, err
 	}
 
 	if len(data) > 0 {
-		c.appendToWbufList(data)
-	}
-
-	i := 0
-	for i < len(c.wbufList) {
-		wbuf := c.wbufList[i]
-		n, err := c.writeToSocket(*wbuf)
-		if errors.Is(err, core.EAGAIN) || errors.Is(err, core.EINTR) || err == nil /*写入成功，也有n != len(*wbuf)的情况*/ {
-			if n == len(*wbuf) {
-				putBytes(wbuf)
-				c.wbufList[i] = nil
-				i++
-				continue
-			}
-			// 移动剩余数据到缓冲区开始位置
-			if err := c.handlePartialWrite(wbuf, n, false); err != nil {
-				c.closeNoLock()
+		if err := c.checkPendingNoLock(len(data)); err != nil {
+			return 0, err
+		}
+		if err := c.queueNoLock(data); err != nil {
+			c.closeNoLock(err)
+			return 0, err
+		}
+	}
+	if err := c.flushNoLock(); err != nil {
+		c.closeNoLock(err)
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// Writev 一次写入多个buffer, 比如header和body, 不需要先拼接
+// 内核写不下的部分和Write一样放到写缓冲区
+func (c *Conn) Writev(bufs [][]byte) (int, error) {
+	if c.adapter == nil {
+		return c.writev(bufs)
+	}
+
+	if c.adapter.writeTimeout() {
+		return 0, os.ErrDeadlineExceeded
+	}
+	n, err := c.writev(bufs)
+	if err != nil {
+		return n, err
+	}
+	return n, c.adapter.waitFlushed(c)
+}
+
+func (c *Conn) writev(bufs [][]byte) (int, error) {
+	c.mu.Lock()
+	defer c.unlock()
+
+	if atomic.LoadInt64(&c.fd) == -1 {
+		return 0, net.ErrClosed
+	}
+	total := 0
+	for _, b := range bufs {
+		total += len(b)
+	}
+	if total == 0 {
+		return 0, nil
+	}
+	if err := c.waitWriterNoLock(); err != nil {
+		return 0, err
+	}
+	if c.closeWrite || c.closing {
+		return 0, ErrWriteClosed
+	}
+
+	// 前面还有数据没发完, 排在后面
+	if c.pendingNoLock() {
+		if err := c.checkPendingNoLock(total); err != nil {
+			return 0, err
+		}
+		for _, b := range bufs {
+			if err := c.queueNoLock(b); err != nil {
+				c.closeNoLock(err)
 				return 0, err
 			}
-
-			// 移动未处理的缓冲区到列表开始位置
-			copy(c.wbufList, c.wbufList[i:])
-			c.wbufList = c.wbufList[:len(c.wbufList)-i]
-			return len(data), nil
 		}
+		if err := c.flushNoLock(); err != nil {
+			c.closeNoLock(err)
+			return 0, err
+		}
+		return total, nil
+	}
 
-		c.closeNoLock()
+	// 和write一样先检查再写
+	if err := c.checkPendingNoLock(total); err != nil {
+		return 0, err
+	}
+	n, err := c.writevToSocket(bufs)
+	if err != nil && !errors.Is(err, core.EAGAIN) && !errors.Is(err, core.EINTR) {
+		c.closeNoLock(err)
 		return n, err
 	}
+	if n == total {
+		return total, nil
+	}
+	// 把剩余数据放到缓冲区
+	for _, b := range bufs {
+		if n >= len(b) {
+			n -= len(b)
+			continue
+		}
+		if err := c.queueNoLock(b[n:]); err != nil {
+			c.closeNoLock(err)
+			return 0, err
+		}
+		n = 0
+	}
+	if err := c.waitWritable(); err != nil {
+		c.closeNoLock(err)
+		return 0, err
+	}
+	return total, nil
+}
 
-	// 所有数据都已写入
-	c.wbufList = c.wbufList[:0]
-	// 需要进的逻辑
-	// 1.如果是垂直触发模式，并且启用了流量背压机制，重新添加读事件
-	// 2.如果是水平触发模式也重新添加读事件，为了去掉写事件
-	// 3.如果是垂直触发模式，并且启用了流量背压机制，则需要添加读事件
+// writevToSocket 同writeToSocket, 一次系统调用写入多个buffer
+func (c *Conn) writevToSocket(bufs [][]byte) (int, error) {
+	n, err := core.Writev(c.getFd(), bufs)
+	if n < 0 {
+		n = 0
+	}
+	if n > 0 && c.idleTimeout > 0 {
+		c.lastActive = time.Now()
+	}
+	return n, err
+}
 
-	// 不需要进的逻辑
-	// 1.如果是垂直触发模式，并且没有启用流量背压机制，不需要重新添加事件, TODO
+// flushNoLock 用writev把写缓冲区一次提交给内核, 需要持有c.mu
+// SendFile的文件按顺序夹在中间, 轮到的时候用sendfile发送
+// 写不完的时候等可写事件, 返回错误的时候需要关闭连接
+func (c *Conn) flushNoLock() error {
+	var iovs [64][]byte
+	for c.pendingNoLock() {
+		// 文件前面的数据已经发完了, 发送文件
+		if len(c.sendFiles) > 0 && c.sendFiles[0].before == 0 {
+			done, err := c.sendFileNoLock(c.sendFiles[0])
+			if err != nil {
+				return err
+			}
+			if !done {
+				return c.waitWritable()
+			}
+			c.sendFiles[0] = nil
+			c.sendFiles = c.sendFiles[1:]
+			continue
+		}
 
+		// 只发送排在下一个文件前面的数据
+		limit := math.MaxInt
+		if len(c.sendFiles) > 0 {
+			limit = c.sendFiles[0].before
+		}
+		bufs, want := iovs[:0], 0
+		for _, wbuf := range c.wbufList {
+			if len(bufs) == len(iovs) || want == limit {
+				break
+			}
+			b := *wbuf
+			if len(b) > limit-want {
+				b = b[:limit-want]
+			}
+			bufs = append(bufs, b)
+			want += len(b)
+		}
+
+		n, err := c.writevToSocket(bufs)
+		if err != nil && !errors.Is(err, core.EAGAIN) && !errors.Is(err, core.EINTR) {
+			return err
+		}
+		c.shrinkBuffered(n)
+		if len(c.sendFiles) > 0 {
+			c.sendFiles[0].before -= n
+		}
+		partial := n < want
+
+		// 释放已经写完的缓冲区, 写了一部分的缓冲区把剩余数据移到开始位置
+		i := 0
+		for i < len(c.wbufList) && n >= len(*c.wbufList[i]) {
+			n -= len(*c.wbufList[i])
+			putBytes(c.wbufList[i])
+			c.wbufList[i] = nil
+			i++
+		}
+		if n > 0 {
+			wbuf := c.wbufList[i]
+			copy(*wbuf, (*wbuf)[n:])
+			*wbuf = (*wbuf)[:len(*wbuf)-n]
+		}
+		copy(c.wbufList, c.wbufList[i:])
+		clear(c.wbufList[len(c.wbufList)-i:])
+		c.wbufList = c.wbufList[:len(c.wbufList)-i]
+
+		// 内核缓冲区满了, 等可写事件
+		if partial {
+			if errors.Is(err, core.EINTR) {
+				continue
+			}
+			return c.waitWritable()
+		}
+	}
+
+	// 所有数据都已写入
+	if c.adapter != nil {
+		notify(c.adapter.writable)
+	}
+	if c.closeWrite {
+		if err := c.shutdownWriteNoLock(); err != nil {
+			slog.Error("failed to shutdown write", "error", err)
+		}
+	}
+	if c.closing {
+		c.closeNoLock(nil)
+		return nil
+	}
+	if c.readEOF || c.readPaused {
+		if err := c.eventLoop.SetWriteOnly(c.getFd(), false); err != nil {
+			slog.Error("failed to delete write event", "error", err)
+		}
+		return nil
+	}
+	// 需要ResetRead的情况
+	// 1.水平触发模式下加过写事件, 需要去掉写事件
+	// 2.流量背压删除过读事件, 需要重新添加读事件
+	// 3.背压暂停过读, 重新注册之后内核会再通知一次可读(垂直触发模式下不会有新的边沿)
+	// 垂直触发模式下只是写缓冲区满过, 读写事件一直都在, 不需要ResetRead
+	if !c.rearmRead {
+		return nil
+	}
+	c.rearmRead = false
 	if err := c.eventLoop.ResetRead(c.getFd()); err != nil {
 		slog.Error("failed to reset read event", "error", err)
 	}
-	return len(data), nil
+	return nil
 }
 
 func (c *Conn) flush() {
-	if _, err := c.Write(nil); err != nil {
+	// 在event loop里调用, 不能等待
+	if _, err := c.write(nil); err != nil {
 		slog.Error("failed to flush write buffer", "error", err)
 	}
 }
@@ -297,8 +735,9 @@ func (c *Conn) GetSession() any {
 // handleData 处理数据的逻辑
 func handleData(c *Conn, options *Options, rawData []byte) {
 
-	if options.taskType == TaskTypeInEventLoop {
-		options.callback.OnData(c, rawData)
+	// 阻塞读写适配器只是把数据复制到读缓冲区, 直接在event loop里调用, 保证数据的顺序
+	if options.taskType == TaskTypeInEventLoop || c.adapter != nil {
+		options.getCallback(c).OnData(c, rawData)
 		return
 	}
 
@@ -309,7 +748,7 @@ func handleData(c *Conn, options *Options, rawData []byte) {
 
 	// 进入协程池
 	if err := c.task.AddTask(&c.mu, func() bool {
-		options.callback.OnData(c, *newBytes)
+		options.getCallback(c).OnData(c, *newBytes)
 		// 释放newBytes
 		if newBytes != nil {
 			putBytes(newBytes)
@@ -328,24 +767,33 @@ func handleData(c *Conn, options *Options, rawData []byte) {
 func (c *Conn) needFlush() bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	return len(c.wbufList) > 0
+	return c.pendingNoLock()
+}
+
+// pendingNoLock 写缓冲区或者SendFile还有数据没有发送, 需要持有c.mu
+func (c *Conn) pendingNoLock() bool {
+	return len(c.wbufList) > 0 || len(c.sendFiles) > 0
 }
 
 func (c *Conn) SetDeadline(t time.Time) error {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlock()
 
 	if atomic.LoadInt64(&c.fd) == -1 {
 		return net.ErrClosed
 	}
 
 	// Set both read and write deadlines
-	if err := c.setReadDeadlineCore(t); err != nil {
+	// 先设置写超时, 已经过去的时间会直接关闭连接, 读超时返回net.ErrClosed
+	if err := c.setWriteDeadlineCore(t); err != nil {
 		return err
 	}
-	return c.setWriteDeadlineCore(t)
+	return c.setReadDeadlineCore(t)
 }
 
+// SetReadDeadline 设置读超时, 到t为止一直没有读到数据的时候关闭连接, OnClose收到os.ErrDeadlineExceeded
+// 每次读到数据之后超时时间会往后推(t - 设置的时候的时间), t为零值时清除超时
+// 在NetConnCallback或者NetListener的连接上和net.Conn一样, 只影响Read, 不会关闭连接
 func (c *Conn) SetReadDeadline(t time.Time) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -358,38 +806,96 @@ func (c *Conn) setReadDeadlineCore(t time.Time) error {
 		return net.ErrClosed
 	}
 
-	// Stop existing timer if any
-	if c.readTimer != nil {
-		c.readTimer.Stop()
-		c.readTimer = nil
+	// 阻塞适配器模式下和net.Conn一样, 超时只影响Read, 不关闭连接
+	if c.adapter != nil {
+		c.adapter.setReadDeadline(t)
+		return nil
 	}
 
-	// If t is zero, we're clearing the deadline
+	// 清除超时时间
 	if t.IsZero() {
+		c.readTimeout = 0
+		if c.readTimer != nil {
+			c.readTimer.Stop()
+			c.readTimer = nil
+		}
 		return nil
 	}
 
-	// Create new timer
-	duration := time.Until(t)
-	if duration <= 0 {
-		c.closeNoLock()
-		return nil
+	// 每次读到数据之后超时时间往后推readTimeout, 超时表示这段时间内一直没有数据
+	c.readDeadline = t
+	c.readTimeout = time.Until(t)
+	d := max(c.readTimeout, 0)
+	// 复用定时器, 已经过去的时间也交给定时器, 在event loop里关闭连接并回调OnClose
+	if c.readTimer != nil {
+		c.readTimer.Reset(d)
+	} else {
+		c.readTimer = newTimer(c.eventLoop, d, c.checkReadDeadline)
 	}
+	return nil
+}
 
-	c.readTimer = time.AfterFunc(duration, func() {
-		c.mu.Lock()
-		defer c.mu.Unlock()
-		if atomic.LoadInt64(&c.fd) != -1 {
-			c.closeNoLock()
-		}
-	})
+// markRead 读到数据之后调用, 推后读超时和空闲超时, 需要持有c.mu
+func (c *Conn) markRead() {
+	if c.readTimeout == 0 && c.idleTimeout == 0 {
+		return
+	}
+	now := time.Now()
+	if c.readTimeout > 0 {
+		c.readDeadline = now.Add(c.readTimeout)
+	}
+	if c.idleTimeout > 0 {
+		c.lastActive = now
+	}
+}
 
-	return nil
+// checkReadDeadline 读超时之后关闭连接, 超时时间被读到的数据推后了就重新等待
+func (c *Conn) checkReadDeadline() {
+	c.mu.Lock()
+	if atomic.LoadInt64(&c.fd) == -1 || c.readTimer == nil {
+		c.mu.Unlock()
+		return
+	}
+	if d := time.Until(c.readDeadline); d > 0 {
+		c.readTimer.Reset(d)
+		c.mu.Unlock()
+		return
+	}
+	c.closeNoLock(os.ErrDeadlineExceeded)
+	c.unlock()
 }
 
-func (c *Conn) SetWriteDeadline(t time.Time) error {
+// startIdleTimer 创建连接的时候调用, 开始空闲检查
+func (c *Conn) startIdleTimer(d time.Duration) {
+	if d <= 0 {
+		return
+	}
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	c.idleTimeout = d
+	c.lastActive = time.Now()
+	c.idleTimer = newTimer(c.eventLoop, d, c.checkIdle)
+}
+
+// checkIdle 空闲超时之后关闭连接, 期间有读写就重新等待
+func (c *Conn) checkIdle() {
+	c.mu.Lock()
+	if atomic.LoadInt64(&c.fd) == -1 || c.idleTimer == nil {
+		c.mu.Unlock()
+		return
+	}
+	if d := c.idleTimeout - time.Since(c.lastActive); d > 0 {
+		c.idleTimer.Reset(d)
+		c.mu.Unlock()
+		return
+	}
+	c.closeNoLock(ErrIdleTimeout)
+	c.unlock()
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.unlock()
 	return c.setWriteDeadlineCore(t)
 }
 
@@ -399,31 +905,55 @@ func (c *Conn) setWriteDeadlineCore(t time.Time) error {
 		return net.ErrClosed
 	}
 
-	// Stop existing timer if any
-	if c.writeTimer != nil {
-		c.writeTimer.Stop()
-		c.writeTimer = nil
+	if c.adapter != nil {
+		c.adapter.setWriteDeadline(t)
+		return nil
 	}
 
-	// If t is zero, we're clearing the deadline
+	// 清除超时时间
 	if t.IsZero() {
+		if c.writeTimer != nil {
+			c.writeTimer.Stop()
+			c.writeTimer = nil
+		}
 		return nil
 	}
 
-	// Create new timer
 	duration := time.Until(t)
 	if duration <= 0 {
-		c.closeNoLock()
+		c.closeNoLock(os.ErrDeadlineExceeded)
 		return nil
 	}
 
-	c.writeTimer = time.AfterFunc(duration, func() {
-		c.mu.Lock()
-		defer c.mu.Unlock()
+	c.writeDeadline = t
+	if c.writeTimer != nil {
+		c.writeTimer.Reset(duration)
+	} else {
+		c.writeTimer = newTimer(c.eventLoop, duration, c.checkWriteDeadline)
+	}
+	return nil
+}
+
+// checkWriteDeadline 写超时之后关闭连接, OnClose收到os.ErrDeadlineExceeded
+func (c *Conn) checkWriteDeadline() {
+	c.mu.Lock()
+	defer c.unlock()
+	if atomic.LoadInt64(&c.fd) == -1 || c.writeTimer == nil {
+		return
+	}
+	// 超时时间被修改了, 新的定时器会再检查
+	if time.Now().Before(c.writeDeadline) {
+		return
+	}
+	c.closeNoLock(os.ErrDeadlineExceeded)
+}
+
+// AfterFunc d之后在连接所属的event loop协程里执行fn, 连接已经关闭的时候不会执行
+// 使用event loop的时间轮, 精度是10ms
+func (c *Conn) AfterFunc(d time.Duration, fn func()) *Timer {
+	return newTimer(c.eventLoop, d, func() {
 		if atomic.LoadInt64(&c.fd) != -1 {
-			c.closeNoLock()
+			fn()
 		}
 	})
-
-	return nil
 }
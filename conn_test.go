@@ -1,9 +1,17 @@
 package pulse
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
 	"net"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
@@ -157,7 +165,7 @@ func TestConn_SetDeadline(t *testing.T) {
 		readBufferSize: 4096,
 		safeConns:      &core.SafeConns[Conn]{},
 	}
-	conn.closeNoLock()
+	conn.closeNoLock(nil)
 	err = conn.SetDeadline(time.Now().Add(time.Second))
 	if err == nil {
 		t.Error("SetDeadline() should return error when connection is closed")
@@ -250,7 +258,7 @@ func TestConn_SetReadDeadline(t *testing.T) {
 		readBufferSize: 4096,
 		safeConns:      &core.SafeConns[Conn]{},
 	}
-	conn.closeNoLock()
+	conn.closeNoLock(nil)
 	err = conn.SetReadDeadline(time.Now().Add(time.Second))
 	if err == nil {
 		t.Error("SetReadDeadline() should return error when connection is closed")
@@ -343,7 +351,7 @@ func TestConn_SetWriteDeadline(t *testing.T) {
 		readBufferSize: 4096,
 		safeConns:      &core.SafeConns[Conn]{},
 	}
-	conn.closeNoLock()
+	conn.closeNoLock(nil)
 	err = conn.SetWriteDeadline(time.Now().Add(time.Second))
 	if err == nil {
 		t.Error("SetWriteDeadline() should return error when connection is closed")
@@ -905,3 +913,562 @@ func TestCallback_ToCallback(t *testing.T) {
 		t.Error("OnClose should receive correct error")
 	}
 }
+
+func TestConn_Addr(t *testing.T) {
+	type addrs struct{ local, remote net.Addr }
+	for _, reusePort := range []bool{false, true} {
+		for _, listenAddr := range []string{"127.0.0.1:0", ":0"} {
+			opened := make(chan addrs, 1)
+			el, err := NewMultiEventLoop(context.Background(),
+				WithCallback(ToCallback(func(c *Conn, err error) {
+					opened <- addrs{c.LocalAddr(), c.RemoteAddr()}
+				}, func(c *Conn, data []byte) {}, func(c *Conn, err error) {})),
+				WithTaskType(TaskTypeInEventLoop),
+				WithReusePort(reusePort))
+			if err != nil {
+				t.Fatalf("NewMultiEventLoop() error = %v", err)
+			}
+			addr, err := el.Listen("tcp", listenAddr)
+			if err != nil {
+				t.Fatalf("Listen() error = %v", err)
+			}
+
+			port := addr.(*net.TCPAddr).Port
+			c := dialRetry(t, "tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+			select {
+			case got := <-opened:
+				if got.local == nil || got.local.String() != c.RemoteAddr().String() {
+					t.Errorf("reusePort=%v %s: LocalAddr() = %v, want %v", reusePort, listenAddr, got.local, c.RemoteAddr())
+				}
+				if got.remote == nil || got.remote.String() != c.LocalAddr().String() {
+					t.Errorf("reusePort=%v %s: RemoteAddr() = %v, want %v", reusePort, listenAddr, got.remote, c.LocalAddr())
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("timeout waiting for OnOpen")
+			}
+			c.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			_ = el.Shutdown(ctx)
+			cancel()
+			el.Free()
+		}
+	}
+}
+
+func TestConn_ReadDeadlineInactivity(t *testing.T) {
+	closeErr := make(chan error, 1)
+	el, err := NewMultiEventLoop(context.Background(),
+		WithCallback(ToCallback(func(c *Conn, err error) {
+			_ = c.SetReadDeadline(time.Now().Add(150 * time.Millisecond))
+		}, func(c *Conn, data []byte) {
+		}, func(c *Conn, err error) {
+			closeErr <- err
+		})),
+		WithTaskType(TaskTypeInEventLoop))
+	if err != nil {
+		t.Fatalf("NewMultiEventLoop() error = %v", err)
+	}
+	defer el.Free()
+	addr, err := el.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = el.Shutdown(ctx)
+	}()
+
+	c := dialRetry(t, "tcp", addr.String())
+	defer c.Close()
+
+	// 一直有数据的时候, 超过最初的超时时间也不会关闭
+	start := time.Now()
+	for time.Since(start) < 400*time.Millisecond {
+		if _, err := c.Write([]byte("ping")); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		select {
+		case err := <-closeErr:
+			t.Fatalf("conn closed while active: %v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	// 不再发送数据之后超时关闭
+	select {
+	case err := <-closeErr:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("OnClose error = %v, want %v", err, os.ErrDeadlineExceeded)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for read deadline")
+	}
+
+	_ = c.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("client Read() error = %v, want EOF", err)
+	}
+}
+
+func TestConn_IdleTimeout(t *testing.T) {
+	closeErr := make(chan error, 1)
+	el, err := NewMultiEventLoop(context.Background(),
+		WithCallback(ToCallback(func(c *Conn, err error) {
+			// 服务端一直写数据, 写也算活跃
+			var push func()
+			pushes := 0
+			push = func() {
+				if pushes++; pushes > 8 {
+					return
+				}
+				_, _ = c.Write([]byte("push"))
+				c.AfterFunc(40*time.Millisecond, push)
+			}
+			c.AfterFunc(40*time.Millisecond, push)
+		}, func(c *Conn, data []byte) {
+		}, func(c *Conn, err error) {
+			closeErr <- err
+		})),
+		WithTaskType(TaskTypeInEventLoop),
+		WithIdleTimeout(150*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewMultiEventLoop() error = %v", err)
+	}
+	defer el.Free()
+	addr, err := el.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = el.Shutdown(ctx)
+	}()
+
+	c := dialRetry(t, "tcp", addr.String())
+	defer c.Close()
+	start := time.Now()
+
+	// 客户端也发一段时间数据, 读也算活跃
+	for time.Since(start) < 200*time.Millisecond {
+		if _, err := c.Write([]byte("ping")); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	select {
+	case err := <-closeErr:
+		if !errors.Is(err, ErrIdleTimeout) {
+			t.Errorf("OnClose error = %v, want %v", err, ErrIdleTimeout)
+		}
+		// 最后一次写在320ms左右
+		if d := time.Since(start); d < 400*time.Millisecond {
+			t.Errorf("closed after %v while still active", d)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for idle timeout")
+	}
+
+	_ = c.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadAll(c); err != nil {
+		t.Errorf("client ReadAll() error = %v, want EOF", err)
+	}
+}
+
+func TestConn_HalfClose(t *testing.T) {
+	for _, taskType := range []TaskType{TaskTypeInEventLoop, TaskTypeInConnectionGoroutine} {
+		for _, triggerType := range []core.TriggerType{TriggerTypeLevel, TriggerTypeEdge} {
+			var mu sync.Mutex
+			var received []byte
+			writeErr := make(chan error, 1)
+			closeErr := make(chan error, 1)
+			el, err := NewMultiEventLoop(context.Background(),
+				WithCallback(ToCallback(func(c *Conn, err error) {
+				}, func(c *Conn, data []byte) {
+					mu.Lock()
+					received = append(received, data...)
+					mu.Unlock()
+				}, func(c *Conn, err error) {
+					closeErr <- err
+				})),
+				WithOnReadEOF(func(c *Conn) {
+					// 对端关闭了写端之后还可以回复
+					mu.Lock()
+					resp := append([]byte("got:"), received...)
+					mu.Unlock()
+					_, _ = c.Write(resp)
+					_ = c.CloseWrite()
+					_, err := c.Write([]byte("more"))
+					writeErr <- err
+				}),
+				WithTaskType(taskType),
+				WithTriggerType(triggerType))
+			if err != nil {
+				t.Fatalf("NewMultiEventLoop() error = %v", err)
+			}
+			addr, err := el.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("Listen() error = %v", err)
+			}
+
+			c := dialRetry(t, "tcp", addr.String())
+			if _, err := c.Write([]byte("hello")); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			if err := c.(*net.TCPConn).CloseWrite(); err != nil {
+				t.Fatalf("CloseWrite() error = %v", err)
+			}
+
+			_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+			resp, err := io.ReadAll(c)
+			if err != nil || string(resp) != "got:hello" {
+				t.Errorf("taskType %d trigger %d: ReadAll() = %q, %v, want got:hello", taskType, triggerType, resp, err)
+			}
+			// 对端已经关闭了写端, CloseWrite之后连接直接关闭
+			if err := <-writeErr; !errors.Is(err, net.ErrClosed) {
+				t.Errorf("Write() after CloseWrite error = %v, want %v", err, net.ErrClosed)
+			}
+			// 两个方向都关闭之后连接被关闭
+			select {
+			case err := <-closeErr:
+				if !errors.Is(err, io.EOF) {
+					t.Errorf("OnClose error = %v, want %v", err, io.EOF)
+				}
+			case <-time.After(2 * time.Second):
+				t.Errorf("taskType %d trigger %d: timeout waiting for OnClose", taskType, triggerType)
+			}
+
+			c.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			_ = el.Shutdown(ctx)
+			cancel()
+			el.Free()
+		}
+	}
+}
+
+// 对端发完数据马上关闭, 数据要先交给OnData再关闭连接
+func TestConn_DataBeforePeerClose(t *testing.T) {
+	for _, triggerType := range []core.TriggerType{TriggerTypeLevel, TriggerTypeEdge} {
+		var mu sync.Mutex
+		var received []byte
+		closeErr := make(chan error, 1)
+		el, err := NewMultiEventLoop(context.Background(),
+			WithCallback(ToCallback(func(c *Conn, err error) {
+			}, func(c *Conn, data []byte) {
+				mu.Lock()
+				received = append(received, data...)
+				mu.Unlock()
+			}, func(c *Conn, err error) {
+				closeErr <- err
+			})),
+			WithTaskType(TaskTypeInEventLoop),
+			WithTriggerType(triggerType),
+			WithEventLoopReadBufferSize(16))
+		if err != nil {
+			t.Fatalf("NewMultiEventLoop() error = %v", err)
+		}
+		addr, err := el.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Listen() error = %v", err)
+		}
+
+		c := dialRetry(t, "tcp", addr.String())
+		msg := strings.Repeat("bye", 100)
+		if _, err := c.Write([]byte(msg)); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		c.Close()
+
+		select {
+		case err := <-closeErr:
+			if !errors.Is(err, io.EOF) {
+				t.Errorf("OnClose error = %v, want %v", err, io.EOF)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("trigger %d: timeout waiting for OnClose", triggerType)
+		}
+		mu.Lock()
+		if string(received) != msg {
+			t.Errorf("trigger %d: received %d bytes, want %d", triggerType, len(received), len(msg))
+		}
+		mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_ = el.Shutdown(ctx)
+		cancel()
+		el.Free()
+	}
+}
+
+func TestConn_CloseAfterFlush(t *testing.T) {
+	const size = 8 << 20
+	for _, tc := range []struct {
+		name    string
+		timeout time.Duration
+		read    bool
+		wantErr error
+	}{
+		{name: "flushed", timeout: 5 * time.Second, read: true, wantErr: nil},
+		{name: "timeout", timeout: 100 * time.Millisecond, read: false, wantErr: os.ErrDeadlineExceeded},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			closeErr := make(chan error, 1)
+			writeErr := make(chan error, 1)
+			el, err := NewMultiEventLoop(context.Background(),
+				WithCallback(ToCallback(func(c *Conn, err error) {
+				}, func(c *Conn, data []byte) {
+					// 数据比内核缓冲区大, 大部分留在写缓冲区里
+					_, _ = c.Write(make([]byte, size))
+					_ = c.CloseAfterFlush(tc.timeout)
+					_, err := c.Write([]byte("more"))
+					writeErr <- err
+				}, func(c *Conn, err error) {
+					closeErr <- err
+				})),
+				WithTaskType(TaskTypeInEventLoop),
+				WithSocketSendBuffer(64*1024))
+			if err != nil {
+				t.Fatalf("NewMultiEventLoop() error = %v", err)
+			}
+			defer el.Free()
+			addr, err := el.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("Listen() error = %v", err)
+			}
+			defer func() {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				defer cancel()
+				_ = el.Shutdown(ctx)
+			}()
+
+			c := dialRetry(t, "tcp", addr.String())
+			defer c.Close()
+			_ = c.(*net.TCPConn).SetReadBuffer(64 * 1024)
+			if _, err := c.Write([]byte("req")); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			if err := <-writeErr; !errors.Is(err, ErrWriteClosed) {
+				t.Errorf("Write() after CloseAfterFlush error = %v, want %v", err, ErrWriteClosed)
+			}
+
+			if tc.read {
+				// 等一会再读, 让数据先堆在写缓冲区里
+				time.Sleep(50 * time.Millisecond)
+				_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+				data, err := io.ReadAll(c)
+				if err != nil || len(data) != size {
+					t.Errorf("ReadAll() = %d bytes, %v, want %d bytes", len(data), err, size)
+				}
+			}
+
+			select {
+			case err := <-closeErr:
+				if err != tc.wantErr {
+					t.Errorf("OnClose error = %v, want %v", err, tc.wantErr)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("timeout waiting for OnClose")
+			}
+		})
+	}
+}
+
+// closeRecorder 记录OnClose的调用次数和原因
+type closeRecorder struct {
+	mu     sync.Mutex
+	errs   []error
+	opened chan struct{}
+	closed chan struct{}
+	onData func(c *Conn)
+	conn   *Conn
+}
+
+func (r *closeRecorder) OnOpen(c *Conn) {
+	r.mu.Lock()
+	r.conn = c
+	r.mu.Unlock()
+	close(r.opened)
+}
+
+func (r *closeRecorder) OnData(c *Conn, data []byte) {
+	if r.onData != nil {
+		r.onData(c)
+	}
+}
+
+func (r *closeRecorder) OnClose(c *Conn, err error) {
+	r.mu.Lock()
+	r.errs = append(r.errs, err)
+	first := len(r.errs) == 1
+	r.mu.Unlock()
+	if first {
+		close(r.closed)
+	}
+	// OnClose里再关闭一次不会再回调
+	_ = c.Close()
+}
+
+func TestConn_OnCloseExactlyOnce(t *testing.T) {
+	isEPIPE := func(err error) bool { return errors.Is(err, syscall.EPIPE) }
+	tests := []struct {
+		name     string
+		opts     []func(*Options)
+		onData   func(c *Conn)
+		client   func(c net.Conn)
+		shutdown bool
+		want     func(err error) bool
+	}{
+		{
+			name:   "peer close",
+			client: func(c net.Conn) { c.Close() },
+			want:   func(err error) bool { return errors.Is(err, io.EOF) },
+		},
+		{
+			name:   "Close",
+			onData: func(c *Conn) { c.Close() },
+			want:   func(err error) bool { return err == nil },
+		},
+		{
+			name:   "past write deadline",
+			onData: func(c *Conn) { _ = c.SetWriteDeadline(time.Now().Add(-time.Second)) },
+			want:   func(err error) bool { return errors.Is(err, os.ErrDeadlineExceeded) },
+		},
+		{
+			name:   "read deadline",
+			onData: func(c *Conn) { _ = c.SetReadDeadline(time.Now().Add(50 * time.Millisecond)) },
+			want:   func(err error) bool { return errors.Is(err, os.ErrDeadlineExceeded) },
+		},
+		{
+			name: "write error",
+			onData: func(c *Conn) {
+				// 绕过CloseWrite直接shutdown, 之后的写会返回EPIPE
+				_ = core.CloseWrite(c.getFd())
+				_, _ = c.Write([]byte("data"))
+			},
+			want: isEPIPE,
+		},
+		{
+			name: "idle timeout",
+			opts: []func(*Options){WithIdleTimeout(50 * time.Millisecond)},
+			want: func(err error) bool { return errors.Is(err, ErrIdleTimeout) },
+		},
+		{
+			name:     "shutdown",
+			shutdown: true,
+			want:     func(err error) bool { return errors.Is(err, ErrServerClosed) },
+		},
+	}
+
+	for _, taskType := range []TaskType{TaskTypeInEventLoop, TaskTypeInBusinessGoroutine, TaskTypeInConnectionGoroutine} {
+		for _, tt := range tests {
+			t.Run(strconv.Itoa(int(taskType))+"/"+tt.name, func(t *testing.T) {
+				r := &closeRecorder{opened: make(chan struct{}), closed: make(chan struct{}), onData: tt.onData}
+				opts := append([]func(*Options){WithCallback(r), WithTaskType(taskType)}, tt.opts...)
+				el, err := NewMultiEventLoop(context.Background(), opts...)
+				if err != nil {
+					t.Fatalf("NewMultiEventLoop() error = %v", err)
+				}
+				defer el.Free()
+				addr, err := el.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatalf("Listen() error = %v", err)
+				}
+				shutdown := func() {
+					ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+					defer cancel()
+					_ = el.Shutdown(ctx)
+				}
+				defer shutdown()
+
+				c := dialRetry(t, "tcp", addr.String())
+				defer c.Close()
+				if _, err := c.Write([]byte("hello")); err != nil {
+					t.Fatalf("Write() error = %v", err)
+				}
+				if tt.client != nil {
+					tt.client(c)
+				}
+				if tt.shutdown {
+					<-r.opened
+					shutdown()
+				}
+
+				select {
+				case <-r.closed:
+				case <-time.After(2 * time.Second):
+					t.Fatal("timeout waiting for OnClose")
+				}
+				// 其他关闭路径不会再回调
+				r.mu.Lock()
+				conn := r.conn
+				r.mu.Unlock()
+				_ = conn.Close()
+				time.Sleep(100 * time.Millisecond)
+
+				r.mu.Lock()
+				defer r.mu.Unlock()
+				if len(r.errs) != 1 {
+					t.Fatalf("OnClose called %d times (%v), want 1", len(r.errs), r.errs)
+				}
+				if !tt.want(r.errs[0]) {
+					t.Errorf("OnClose error = %v", r.errs[0])
+				}
+			})
+		}
+	}
+}
+
+func TestConn_Writev(t *testing.T) {
+	const rounds = 2000
+	header := []byte("HEADER:")
+	body := bytes.Repeat([]byte("b"), 4000)
+	trailer := []byte("\n")
+	el, err := NewMultiEventLoop(context.Background(),
+		WithCallback(ToCallback(func(c *Conn, err error) {
+		}, func(c *Conn, data []byte) {
+			// 内核缓冲区很小, 后面的数据会堆在写缓冲区里, 由writev一起发送
+			for i := 0; i < rounds; i++ {
+				if _, err := c.Writev([][]byte{header, body, trailer}); err != nil {
+					t.Errorf("Writev() error = %v", err)
+					return
+				}
+			}
+		}, func(c *Conn, err error) {})),
+		WithTaskType(TaskTypeInEventLoop),
+		WithSocketSendBuffer(64*1024))
+	if err != nil {
+		t.Fatalf("NewMultiEventLoop() error = %v", err)
+	}
+	defer el.Free()
+	addr, err := el.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = el.Shutdown(ctx)
+	}()
+
+	c := dialRetry(t, "tcp", addr.String())
+	defer c.Close()
+	if _, err := c.Write([]byte("go")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	want := bytes.Join([][]byte{header, body, trailer}, nil)
+	got := make([]byte, len(want)*rounds)
+	_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(c, got); err != nil {
+		t.Fatalf("ReadFull() error = %v", err)
+	}
+	for i := 0; i < rounds; i++ {
+		if !bytes.Equal(got[i*len(want):(i+1)*len(want)], want) {
+			t.Fatalf("response %d corrupted", i)
+		}
+	}
+}
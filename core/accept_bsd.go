@@ -0,0 +1,30 @@
+//go:build darwin || freebsd
+
+package core
+
+import (
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// AcceptNonblock 从非阻塞的监听socket里accept一个连接, 返回的fd是非阻塞的
+// darwin没有accept4, 需要单独设置O_NONBLOCK和FD_CLOEXEC
+func AcceptNonblock(fd int) (nfd int, raddr net.Addr, err error) {
+	syscall.ForkLock.RLock()
+	nfd, sa, err := unix.Accept(fd)
+	if err == nil {
+		unix.CloseOnExec(nfd)
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return -1, nil, err
+	}
+
+	if err = unix.SetNonblock(nfd, true); err != nil {
+		_ = unix.Close(nfd)
+		return -1, nil, err
+	}
+	return nfd, SockaddrToAddr(sa), nil
+}
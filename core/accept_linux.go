@@ -0,0 +1,18 @@
+//go:build linux
+
+package core
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// AcceptNonblock 从非阻塞的监听socket里accept一个连接, 返回的fd是非阻塞的
+func AcceptNonblock(fd int) (nfd int, raddr net.Addr, err error) {
+	nfd, sa, err := unix.Accept4(fd, unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC)
+	if err != nil {
+		return -1, nil, err
+	}
+	return nfd, SockaddrToAddr(sa), nil
+}
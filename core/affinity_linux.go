@@ -0,0 +1,37 @@
+//go:build linux
+
+package core
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// BindCPU 把当前线程绑定到进程允许使用的第index个cpu上(按取模选择)
+// 调用之前需要runtime.LockOSThread
+func BindCPU(index int) error {
+	var allowed unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &allowed); err != nil {
+		return err
+	}
+
+	count := allowed.Count()
+	if count == 0 {
+		return errors.New("no cpu available")
+	}
+	index %= count
+
+	for cpu := 0; cpu < len(allowed)*64; cpu++ {
+		if !allowed.IsSet(cpu) {
+			continue
+		}
+		if index == 0 {
+			var set unix.CPUSet
+			set.Set(cpu)
+			return unix.SchedSetaffinity(0, &set)
+		}
+		index--
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+//go:build linux
+
+package core
+
+import (
+	"runtime"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestBindCPU(t *testing.T) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// 线程绑定了cpu, 协程退出的时候线程也一起退出
+		runtime.LockOSThread()
+
+		if err := BindCPU(1); err != nil {
+			t.Errorf("BindCPU() error = %v", err)
+			return
+		}
+
+		var set unix.CPUSet
+		if err := unix.SchedGetaffinity(0, &set); err != nil {
+			t.Errorf("SchedGetaffinity() error = %v", err)
+			return
+		}
+		if set.Count() != 1 {
+			t.Errorf("cpu count after BindCPU = %d, want 1", set.Count())
+		}
+	}()
+	<-done
+}
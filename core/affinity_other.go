@@ -0,0 +1,8 @@
+//go:build !linux
+
+package core
+
+// BindCPU 只有linux支持绑定cpu, 其他平台什么都不做
+func BindCPU(index int) error {
+	return nil
+}
@@ -14,6 +14,8 @@ const (
 	WRITE State = 1 << iota
 	// 可读
 	READ
+	// 对端关闭了写端(EPOLLRDHUP/EV_EOF), 会和READ一起出现, 读完剩下的数据之后read返回0
+	RDHUP
 )
 
 // 水平触发还是边缘触发
@@ -32,6 +34,9 @@ func (s State) String() string {
 	if s.IsWrite() {
 		sb.WriteString("WRITE")
 	}
+	if s.IsRDHUP() {
+		sb.WriteString("RDHUP")
+	}
 	return sb.String()
 }
 
@@ -43,13 +48,21 @@ func (s State) IsRead() bool {
 	return s&READ != 0
 }
 
+func (s State) IsRDHUP() bool {
+	return s&RDHUP != 0
+}
+
 type PollingApi interface {
 	AddRead(fd int) error
 	AddWrite(fd int) error
 	ResetRead(fd int) error
 	DelRead(fd int) error
+	// 只关注写事件, 对端半关闭之后不再关注读事件, write为false的时候只剩错误事件
+	SetWriteOnly(fd int, write bool) error
 	Del(fd int) error
 	Poll(tv time.Duration, cb func(int, State, error)) (retVal int, err error)
+	// 从其他协程唤醒阻塞在Poll里的event loop, 被唤醒的Poll不会回调cb
+	Wake() error
 	Free()
 	Name() string
 }
@@ -18,11 +18,14 @@
 package core
 
 import (
+	"encoding/binary"
 	"errors"
 	"io"
 	"log/slog"
 	"syscall"
 	"time"
+
+	"golang.org/x/sys/unix"
 )
 
 const (
@@ -55,6 +58,7 @@ var _ PollingApi = (*eventPollState)(nil)
 
 type eventPollState struct {
 	epfd   int
+	wakeFd int // eventfd, 用于跨协程唤醒epoll_wait
 	events []syscall.EpollEvent
 
 	et      bool
@@ -81,20 +85,63 @@ func Create(triggerType TriggerType) (la PollingApi, err error) {
 		return nil, err
 	}
 
+	e.wakeFd, err = unix.Eventfd(0, unix.EFD_NONBLOCK|unix.EFD_CLOEXEC)
+	if err != nil {
+		_ = syscall.Close(e.epfd)
+		return nil, err
+	}
+
+	// 唤醒事件使用水平触发, Poll里面会把计数读掉
+	if err = syscall.EpollCtl(e.epfd, syscall.EPOLL_CTL_ADD, e.wakeFd, &syscall.EpollEvent{
+		Fd:     int32(e.wakeFd),
+		Events: syscall.EPOLLIN,
+	}); err != nil {
+		_ = syscall.Close(e.wakeFd)
+		_ = syscall.Close(e.epfd)
+		return nil, err
+	}
+
 	slog.Info("create epoll", "triggerType", triggerType)
 	e.events = make([]syscall.EpollEvent, 1024)
-	e.rev, e.wev, e.drEv, e.dwEv, e.resetEv = getReadWriteDeleteReset(triggerType == TriggerTypeEdge)
+	e.et = triggerType == TriggerTypeEdge
+	e.rev, e.wev, e.drEv, e.dwEv, e.resetEv = getReadWriteDeleteReset(e.et)
 	return &e, nil
 }
 
 // 释放
 func (e *eventPollState) Free() {
+	if err := syscall.Close(e.wakeFd); err != nil {
+		slog.Warn("failed to close eventfd", "error", err)
+	}
 	if err := syscall.Close(e.epfd); err != nil {
 		// Log the error but don't panic as this is a cleanup function
 		slog.Warn("failed to close epoll fd", "error", err)
 	}
 }
 
+// 唤醒epoll_wait
+func (e *eventPollState) Wake() error {
+	var buf [8]byte
+	binary.NativeEndian.PutUint64(buf[:], 1)
+	_, err := syscall.Write(e.wakeFd, buf[:])
+	// EAGAIN说明计数器已经满了, 已经有唤醒事件在等待处理
+	if err != nil && !errors.Is(err, syscall.EAGAIN) {
+		return err
+	}
+	return nil
+}
+
+// 读掉eventfd的计数, 不然水平触发会一直唤醒
+func (e *eventPollState) drainWake() {
+	var buf [8]byte
+	for {
+		_, err := syscall.Read(e.wakeFd, buf[:])
+		if !errors.Is(err, syscall.EINTR) {
+			return
+		}
+	}
+}
+
 // 新加读事件
 func (e *eventPollState) AddRead(fd int) error {
 	if e.rev > 0 && fd >= 0 {
@@ -143,14 +190,33 @@ func (e *eventPollState) DelWrite(fd int) error {
 func (e *eventPollState) DelRead(fd int) error {
 	if fd > 0 {
 		// 移除读事件，只保留写事件
+		events := uint32(syscall.EPOLLOUT)
+		if e.et {
+			events |= uint32(-syscall.EPOLLET)
+		}
 		return syscall.EpollCtl(e.epfd, syscall.EPOLL_CTL_MOD, fd, &syscall.EpollEvent{
 			Fd:     int32(fd),
-			Events: uint32(syscall.EPOLLOUT),
+			Events: events,
 		})
 	}
 	return nil
 }
 
+// 只关注写事件
+func (e *eventPollState) SetWriteOnly(fd int, write bool) error {
+	var events uint32
+	if write {
+		events = syscall.EPOLLOUT
+		if e.et {
+			events |= uint32(-syscall.EPOLLET)
+		}
+	}
+	return syscall.EpollCtl(e.epfd, syscall.EPOLL_CTL_MOD, fd, &syscall.EpollEvent{
+		Fd:     int32(fd),
+		Events: events,
+	})
+}
+
 // 删除事件
 func (e *eventPollState) Del(fd int) error {
 	return syscall.EpollCtl(e.epfd, syscall.EPOLL_CTL_DEL, fd, &syscall.EpollEvent{Fd: int32(fd)})
@@ -174,9 +240,12 @@ func (e *eventPollState) Poll(tv time.Duration, cb func(fd int, state State, err
 	for i := 0; i < numEvents; i++ {
 		ev := &e.events[i]
 		fd := ev.Fd
+		if int(fd) == e.wakeFd {
+			e.drainWake()
+			continue
+		}
 
-		// unix.EPOLLRDHUP是关闭事件，遇到直接关闭
-		if ev.Events&(syscall.EPOLLERR|syscall.EPOLLHUP|syscall.EPOLLRDHUP) > 0 {
+		if ev.Events&syscall.EPOLLERR > 0 {
 			cb(int(fd), WRITE|READ, io.EOF)
 			continue
 		}
@@ -185,6 +254,11 @@ func (e *eventPollState) Poll(tv time.Duration, cb func(fd int, state State, err
 		if ev.Events&processRead > 0 {
 			state |= READ
 		}
+		// EPOLLRDHUP是对端关闭了写端, 接收缓冲区里可能还有数据, 交给上层读到0为止
+		// EPOLLHUP是两个方向都关闭了, 一样先把剩下的数据读完
+		if ev.Events&(syscall.EPOLLHUP|syscall.EPOLLRDHUP) > 0 {
+			state |= READ | RDHUP
+		}
 		if ev.Events&processWrite > 0 {
 			state |= WRITE
 		}
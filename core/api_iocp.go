@@ -19,6 +19,9 @@ var (
 	closeHandle                = kernel32.NewProc("CloseHandle")
 )
 
+// PostQueuedCompletionStatus唤醒时使用的completion key
+const wakeKey = ^uintptr(0)
+
 type iocp struct {
 	handle windows.Handle
 	events map[int]State
@@ -61,6 +64,15 @@ func (i *iocp) DelRead(fd int) error {
 	return nil
 }
 
+func (i *iocp) SetWriteOnly(fd int, write bool) error {
+	if write {
+		i.events[fd] = WRITE
+	} else {
+		i.events[fd] = 0
+	}
+	return nil
+}
+
 func (i *iocp) Del(fd int) error {
 	delete(i.events, fd)
 	return nil
@@ -68,7 +80,7 @@ func (i *iocp) Del(fd int) error {
 
 func (i *iocp) Poll(tv time.Duration, cb func(int, State, error)) (retVal int, err error) {
 	var bytes uint32
-	var key uint32
+	var key uintptr
 	var overlapped *windows.Overlapped
 	var timeout uint32
 
@@ -93,6 +105,10 @@ func (i *iocp) Poll(tv time.Duration, cb func(int, State, error)) (retVal int, e
 		return 0, err
 	}
 
+	if key == wakeKey {
+		return 0, nil
+	}
+
 	fd := int(key)
 	state := i.events[fd]
 	if state == 0 {
@@ -103,6 +119,20 @@ func (i *iocp) Poll(tv time.Duration, cb func(int, State, error)) (retVal int, e
 	return 1, nil
 }
 
+// 唤醒GetQueuedCompletionStatus
+func (i *iocp) Wake() error {
+	ret, _, err := postQueuedCompletionStatus.Call(
+		uintptr(i.handle),
+		0,
+		wakeKey,
+		0,
+	)
+	if ret == 0 {
+		return err
+	}
+	return nil
+}
+
 func (i *iocp) Free() {
 	if i.handle != windows.InvalidHandle {
 		closeHandle.Call(uintptr(i.handle))
@@ -28,6 +28,9 @@ import (
 
 var _ PollingApi = (*eventPollState)(nil)
 
+// EVFILT_USER事件的标识, 用于跨协程唤醒kevent
+const wakeIdent = 0
+
 type eventPollState struct {
 	kqfd   int
 	events []unix.Kevent_t
@@ -41,9 +44,25 @@ func Create(triggerType TriggerType) (as PollingApi, err error) {
 	}
 	state.events = make([]unix.Kevent_t, 1024)
 
+	_, err = unix.Kevent(state.kqfd, []unix.Kevent_t{
+		{Ident: wakeIdent, Flags: unix.EV_ADD | unix.EV_CLEAR, Filter: unix.EVFILT_USER},
+	}, nil, nil)
+	if err != nil {
+		_ = unix.Close(state.kqfd)
+		return nil, err
+	}
+
 	return &state, nil
 }
 
+// 唤醒kevent
+func (as *eventPollState) Wake() error {
+	_, err := unix.Kevent(as.kqfd, []unix.Kevent_t{
+		{Ident: wakeIdent, Filter: unix.EVFILT_USER, Fflags: unix.NOTE_TRIGGER},
+	}, nil, nil)
+	return err
+}
+
 // 新加读事件
 func (as *eventPollState) AddRead(fd int) error {
 	if fd == -1 {
@@ -68,15 +87,23 @@ func (as *eventPollState) AddWrite(fd int) error {
 	return err
 }
 
+// 恢复只关注读事件
+// 重新添加读filter, DelRead删除过的读事件会恢复, 已经可读的数据也会再通知一次
 func (as *eventPollState) ResetRead(fd int) error {
 	if fd == -1 {
 		return nil
 	}
 
-	_, err := unix.Kevent(as.kqfd, []unix.Kevent_t{
+	changes := []unix.Kevent_t{
+		{Ident: uint64(fd), Flags: unix.EV_ADD | unix.EV_CLEAR, Filter: unix.EVFILT_READ},
 		{Ident: uint64(fd), Flags: unix.EV_DELETE, Filter: unix.EVFILT_WRITE},
-	}, nil, nil)
-	return err
+	}
+	for _, ch := range changes {
+		if _, err := unix.Kevent(as.kqfd, []unix.Kevent_t{ch}, nil, nil); err != nil && !errors.Is(err, unix.ENOENT) {
+			return err
+		}
+	}
+	return nil
 }
 
 func (as *eventPollState) DelRead(fd int) error {
@@ -90,6 +117,28 @@ func (as *eventPollState) DelRead(fd int) error {
 	return err
 }
 
+// 只关注写事件
+func (as *eventPollState) SetWriteOnly(fd int, write bool) error {
+	if fd == -1 {
+		return nil
+	}
+
+	changes := []unix.Kevent_t{
+		{Ident: uint64(fd), Flags: unix.EV_DELETE, Filter: unix.EVFILT_READ},
+		{Ident: uint64(fd), Flags: unix.EV_DELETE, Filter: unix.EVFILT_WRITE},
+	}
+	if write {
+		changes[1].Flags = unix.EV_ADD | unix.EV_CLEAR
+	}
+	// 已经删除过的filter会返回ENOENT, 分开提交
+	for _, ch := range changes {
+		if _, err := unix.Kevent(as.kqfd, []unix.Kevent_t{ch}, nil, nil); err != nil && !errors.Is(err, unix.ENOENT) {
+			return err
+		}
+	}
+	return nil
+}
+
 func (as *eventPollState) Del(fd int) error {
 	// _, err := unix.Kevent(as.kqfd, []unix.Kevent_t{
 	// 	{Ident: uint64(fd), Flags: unix.EV_DELETE, Filter: unix.EVFILT_READ},
@@ -101,7 +150,8 @@ func (as *eventPollState) Del(fd int) error {
 
 func (as *eventPollState) Poll(tv time.Duration, cb func(int, State, error)) (retVal int, err error) {
 	var timeout *unix.Timespec
-	if tv >= 0 {
+	// 和epoll保持一致, tv <= 0 一直阻塞
+	if tv > 0 {
 		var tempTimeout unix.Timespec
 		tempTimeout.Sec = int64(tv / time.Second)
 		tempTimeout.Nsec = int64(tv % time.Second)
@@ -120,8 +170,12 @@ func (as *eventPollState) Poll(tv time.Duration, cb func(int, State, error)) (re
 		for j := 0; j < retVal; j++ {
 			ev := &as.events[j]
 			fd := int(ev.Ident)
+			if ev.Filter == unix.EVFILT_USER {
+				continue
+			}
 
-			if ev.Flags&unix.EV_EOF != 0 {
+			// 读事件上的EV_EOF是对端关闭了写端, 接收缓冲区里可能还有数据, 交给上层读到0为止
+			if ev.Flags&unix.EV_EOF != 0 && ev.Filter != unix.EVFILT_READ {
 				cb(fd, WRITE, io.EOF)
 				continue
 			}
@@ -129,6 +183,9 @@ func (as *eventPollState) Poll(tv time.Duration, cb func(int, State, error)) (re
 			var state State
 			if ev.Filter == unix.EVFILT_READ {
 				state |= READ
+				if ev.Flags&unix.EV_EOF != 0 {
+					state |= RDHUP
+				}
 			}
 			if ev.Filter == unix.EVFILT_WRITE {
 				state |= WRITE
@@ -0,0 +1,45 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPollingApi_Wake(t *testing.T) {
+	for _, triggerType := range []TriggerType{TriggerTypeLevel, TriggerTypeEdge} {
+		e, err := Create(triggerType)
+		if err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			called := false
+			if _, err := e.Poll(0, func(fd int, state State, err error) {
+				called = true
+			}); err != nil {
+				t.Errorf("Poll() error = %v", err)
+			}
+			if called {
+				t.Error("Poll() should not call cb for wake event")
+			}
+		}()
+
+		time.Sleep(10 * time.Millisecond)
+		if err := e.Wake(); err != nil {
+			t.Fatalf("Wake() error = %v", err)
+		}
+		// 多次唤醒不应该报错
+		if err := e.Wake(); err != nil {
+			t.Fatalf("Wake() twice error = %v", err)
+		}
+
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Poll() was not woken up")
+		}
+		e.Free()
+	}
+}
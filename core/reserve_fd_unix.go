@@ -0,0 +1,63 @@
+//go:build !windows
+
+package core
+
+import (
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// ReserveFd 预留一个fd, fd耗尽(EMFILE/ENFILE)的时候, 先关闭预留的fd腾出位置,
+// 把连接accept出来马上关闭, 然后再把fd预留回来
+// 不然监听socket一直可读, 水平触发会空转, 客户端也只能等到超时
+type ReserveFd struct {
+	mu sync.Mutex
+	fd int
+}
+
+func NewReserveFd() *ReserveFd {
+	r := &ReserveFd{fd: -1}
+	r.open()
+	return r
+}
+
+func (r *ReserveFd) open() {
+	fd, err := unix.Open("/dev/null", unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		r.fd = -1
+		return
+	}
+	r.fd = fd
+}
+
+// Shed 释放预留的fd之后调用accept, accept需要自己关闭接收到的连接
+// 没有预留的fd(之前没有重新预留成功)时返回false
+func (r *ReserveFd) Shed(accept func()) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.fd < 0 {
+		r.open()
+		if r.fd < 0 {
+			return false
+		}
+	}
+
+	_ = unix.Close(r.fd)
+	r.fd = -1
+	accept()
+	r.open()
+	return true
+}
+
+func (r *ReserveFd) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fd < 0 {
+		return nil
+	}
+	err := unix.Close(r.fd)
+	r.fd = -1
+	return err
+}
@@ -0,0 +1,18 @@
+//go:build windows
+
+package core
+
+// ReserveFd windows下没有实现, 不会丢弃连接
+type ReserveFd struct{}
+
+func NewReserveFd() *ReserveFd {
+	return &ReserveFd{}
+}
+
+func (r *ReserveFd) Shed(accept func()) bool {
+	return false
+}
+
+func (r *ReserveFd) Close() error {
+	return nil
+}
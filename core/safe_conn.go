@@ -117,6 +117,20 @@ func (s *SafeConns[T]) Get(fd int) *T {
 			uintptr(fd)))))
 }
 
+// Range 遍历所有连接, 可以和Add/Del并发调用, f返回false的时候停止遍历
+func (s *SafeConns[T]) Range(f func(fd int, c *T) bool) {
+	l := int(atomic.LoadUintptr(&s.len))
+	for fd := 0; fd < l; fd++ {
+		c := s.Get(fd)
+		if c == nil {
+			continue
+		}
+		if !f(fd, c) {
+			return
+		}
+	}
+}
+
 func (s *SafeConns[T]) UnsafeConns() []*T {
 	return s.conns
 }
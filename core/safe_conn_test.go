@@ -233,3 +233,35 @@ func BenchmarkSafeConns_Get_NonAtomic_OutOfRange(b *testing.B) {
 		_ = safeConns.GetNonAtomic(1000)
 	}
 }
+
+func TestSafeConns_Range(t *testing.T) {
+	var safeConns SafeConns[testConn]
+	safeConns.Init(100)
+
+	safeConns.Add(3, &testConn{id: 3})
+	safeConns.Add(7, &testConn{id: 7})
+	safeConns.Add(9, &testConn{id: 9})
+	safeConns.Del(7)
+
+	var got []int
+	safeConns.Range(func(fd int, c *testConn) bool {
+		if fd != c.id {
+			t.Errorf("Range() fd = %d, id = %d", fd, c.id)
+		}
+		got = append(got, fd)
+		return true
+	})
+	if len(got) != 2 || got[0] != 3 || got[1] != 9 {
+		t.Errorf("Range() got %v, want [3 9]", got)
+	}
+
+	// 提前结束遍历
+	count := 0
+	safeConns.Range(func(fd int, c *testConn) bool {
+		count++
+		return false
+	})
+	if count != 1 {
+		t.Errorf("Range() stop early visited %d, want 1", count)
+	}
+}
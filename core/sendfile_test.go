@@ -0,0 +1,52 @@
+//go:build linux || darwin || freebsd
+
+package core
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestSendFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "blob")
+	if err := os.WriteFile(name, []byte("0123456789"), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer f.Close()
+
+	src, err := Dup(int(f.Fd()))
+	if err != nil {
+		t.Fatalf("Dup() error = %v", err)
+	}
+	defer unix.Close(src)
+
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatalf("Socketpair() error = %v", err)
+	}
+	defer unix.Close(fds[0])
+	defer unix.Close(fds[1])
+
+	n, err := SendFile(fds[0], src, 3, 4)
+	if err != nil || n != 4 {
+		t.Fatalf("SendFile() = %d, %v, want 4", n, err)
+	}
+	got := make([]byte, 16)
+	n, err = unix.Read(fds[1], got)
+	if err != nil || !bytes.Equal(got[:n], []byte("3456")) {
+		t.Errorf("Read() = %q, %v, want 3456", got[:n], err)
+	}
+
+	// 文件末尾返回0
+	if n, err := SendFile(fds[0], src, 10, 4); err != nil || n != 0 {
+		t.Errorf("SendFile() at EOF = %d, %v, want 0", n, err)
+	}
+}
@@ -0,0 +1,23 @@
+//go:build linux || darwin || freebsd
+
+package core
+
+import "golang.org/x/sys/unix"
+
+// SendFileSupported 当前平台是否支持sendfile
+const SendFileSupported = true
+
+// SendFile 用sendfile把src从offset开始的count个字节写到socket dst, 数据不经过用户态
+// 返回写入的字节数, 内核缓冲区满了的时候返回部分写入的字节数和EAGAIN
+func SendFile(dst, src int, offset int64, count int) (n int, err error) {
+	n, err = unix.Sendfile(dst, src, &offset, count)
+	if n < 0 {
+		n = 0
+	}
+	return n, err
+}
+
+// Dup 复制文件描述符, 新的描述符带close-on-exec
+func Dup(fd int) (int, error) {
+	return unix.FcntlInt(uintptr(fd), unix.F_DUPFD_CLOEXEC, 0)
+}
@@ -0,0 +1,28 @@
+//go:build darwin || freebsd
+
+package core
+
+import (
+	"errors"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const tcpCork = unix.TCP_NOPUSH
+
+func SetTCPUserTimeout(fd int, d time.Duration) error {
+	return errors.ErrUnsupported
+}
+
+func GetTCPUserTimeout(fd int) (time.Duration, error) {
+	return 0, errors.ErrUnsupported
+}
+
+func SetQuickAck(fd int, quickack bool) error {
+	return errors.ErrUnsupported
+}
+
+func GetQuickAck(fd int) (bool, error) {
+	return false, errors.ErrUnsupported
+}
@@ -0,0 +1,8 @@
+//go:build darwin
+
+package core
+
+import "golang.org/x/sys/unix"
+
+// darwin下面没有TCP_KEEPIDLE, 对应的是TCP_KEEPALIVE
+const tcpKeepIdle = unix.TCP_KEEPALIVE
@@ -0,0 +1,7 @@
+//go:build freebsd
+
+package core
+
+import "golang.org/x/sys/unix"
+
+const tcpKeepIdle = unix.TCP_KEEPIDLE
@@ -0,0 +1,35 @@
+//go:build linux
+
+package core
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	tcpKeepIdle = unix.TCP_KEEPIDLE
+	tcpCork     = unix.TCP_CORK
+)
+
+// SetTCPUserTimeout 发出去的数据超过d没有被确认的时候内核关闭连接, 0表示使用系统默认值
+func SetTCPUserTimeout(fd int, d time.Duration) error {
+	return unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_USER_TIMEOUT, int(d/time.Millisecond))
+}
+
+func GetTCPUserTimeout(fd int) (time.Duration, error) {
+	ms, err := unix.GetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_USER_TIMEOUT)
+	return time.Duration(ms) * time.Millisecond, err
+}
+
+// SetQuickAck 打开TCP_QUICKACK, 立即回复ack而不是延迟ack
+// 内核会在一段时间之后自动关闭, 需要的话每次读完数据再设置一次
+func SetQuickAck(fd int, quickack bool) error {
+	return unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_QUICKACK, boolToInt(quickack))
+}
+
+func GetQuickAck(fd int) (bool, error) {
+	v, err := unix.GetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_QUICKACK)
+	return v != 0, err
+}
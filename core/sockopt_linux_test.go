@@ -0,0 +1,140 @@
+//go:build linux
+
+package core
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// testTCPFd 返回一个已连接的tcp socket
+func testTCPFd(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	fd, err := GetFdFromConn(c)
+	if err != nil {
+		t.Fatalf("GetFdFromConn() error = %v", err)
+	}
+	t.Cleanup(func() { Close(fd) })
+	return fd
+}
+
+func getsockopt(t *testing.T, fd, level, opt int) int {
+	t.Helper()
+	v, err := unix.GetsockoptInt(fd, level, opt)
+	if err != nil {
+		t.Fatalf("GetsockoptInt(%d) error = %v", opt, err)
+	}
+	return v
+}
+
+func TestKeepAlive(t *testing.T) {
+	fd := testTCPFd(t)
+	if err := SetKeepAlive(fd, true); err != nil {
+		t.Fatalf("SetKeepAlive() error = %v", err)
+	}
+	if err := SetKeepAliveIdle(fd, 30*time.Second); err != nil {
+		t.Fatalf("SetKeepAliveIdle() error = %v", err)
+	}
+	// 不足1秒向上取整
+	if err := SetKeepAliveInterval(fd, 1500*time.Millisecond); err != nil {
+		t.Fatalf("SetKeepAliveInterval() error = %v", err)
+	}
+	if err := SetKeepAliveCount(fd, 3); err != nil {
+		t.Fatalf("SetKeepAliveCount() error = %v", err)
+	}
+
+	if v := getsockopt(t, fd, unix.SOL_SOCKET, unix.SO_KEEPALIVE); v != 1 {
+		t.Errorf("SO_KEEPALIVE = %d, want 1", v)
+	}
+	if v := getsockopt(t, fd, unix.IPPROTO_TCP, unix.TCP_KEEPIDLE); v != 30 {
+		t.Errorf("TCP_KEEPIDLE = %d, want 30", v)
+	}
+	if v := getsockopt(t, fd, unix.IPPROTO_TCP, unix.TCP_KEEPINTVL); v != 2 {
+		t.Errorf("TCP_KEEPINTVL = %d, want 2", v)
+	}
+	if v := getsockopt(t, fd, unix.IPPROTO_TCP, unix.TCP_KEEPCNT); v != 3 {
+		t.Errorf("TCP_KEEPCNT = %d, want 3", v)
+	}
+
+	if err := SetKeepAlive(fd, false); err != nil {
+		t.Fatalf("SetKeepAlive(false) error = %v", err)
+	}
+	if v := getsockopt(t, fd, unix.SOL_SOCKET, unix.SO_KEEPALIVE); v != 0 {
+		t.Errorf("SO_KEEPALIVE = %d, want 0", v)
+	}
+}
+
+func TestSocketOptions(t *testing.T) {
+	fd := testTCPFd(t)
+
+	if err := SetSendBufferSize(fd, 64*1024); err != nil {
+		t.Fatalf("SetSendBufferSize() error = %v", err)
+	}
+	// linux会把设置的值翻倍
+	if v, err := GetSendBufferSize(fd); err != nil || v != 128*1024 {
+		t.Errorf("GetSendBufferSize() = %d, %v, want %d", v, err, 128*1024)
+	}
+	if err := SetRecvBufferSize(fd, 32*1024); err != nil {
+		t.Fatalf("SetRecvBufferSize() error = %v", err)
+	}
+	if v, err := GetRecvBufferSize(fd); err != nil || v != 64*1024 {
+		t.Errorf("GetRecvBufferSize() = %d, %v, want %d", v, err, 64*1024)
+	}
+
+	if v, err := GetLinger(fd); err != nil || v != -1 {
+		t.Errorf("GetLinger() = %d, %v, want -1", v, err)
+	}
+	if err := SetLinger(fd, 0); err != nil {
+		t.Fatalf("SetLinger() error = %v", err)
+	}
+	if v, err := GetLinger(fd); err != nil || v != 0 {
+		t.Errorf("GetLinger() = %d, %v, want 0", v, err)
+	}
+
+	if err := SetTCPUserTimeout(fd, 3*time.Second); err != nil {
+		t.Fatalf("SetTCPUserTimeout() error = %v", err)
+	}
+	if v, err := GetTCPUserTimeout(fd); err != nil || v != 3*time.Second {
+		t.Errorf("GetTCPUserTimeout() = %v, %v, want 3s", v, err)
+	}
+
+	if err := SetQuickAck(fd, true); err != nil {
+		t.Fatalf("SetQuickAck() error = %v", err)
+	}
+	if v, err := GetQuickAck(fd); err != nil || !v {
+		t.Errorf("GetQuickAck() = %v, %v, want true", v, err)
+	}
+
+	if err := SetCork(fd, true); err != nil {
+		t.Fatalf("SetCork() error = %v", err)
+	}
+	if v, err := GetCork(fd); err != nil || !v {
+		t.Errorf("GetCork() = %v, %v, want true", v, err)
+	}
+	if err := SetCork(fd, false); err != nil {
+		t.Fatalf("SetCork(false) error = %v", err)
+	}
+	if v, err := GetCork(fd); err != nil || v {
+		t.Errorf("GetCork() = %v, %v, want false", v, err)
+	}
+
+	if err := SetTOS(fd, 0x28); err != nil {
+		t.Fatalf("SetTOS() error = %v", err)
+	}
+	if v, err := GetTOS(fd); err != nil || v != 0x28 {
+		t.Errorf("GetTOS() = %#x, %v, want 0x28", v, err)
+	}
+}
@@ -3,9 +3,11 @@
 package core
 
 import (
+	"context"
 	"errors"
 	"net"
 	"syscall"
+	"time"
 
 	"golang.org/x/sys/unix"
 )
@@ -25,6 +27,11 @@ func Close(fd int) error {
 	return syscall.Close(fd)
 }
 
+// CloseWrite 关闭写端(shutdown SHUT_WR), 对端会读到EOF
+func CloseWrite(fd int) error {
+	return syscall.Shutdown(fd, syscall.SHUT_WR)
+}
+
 func SetNoDelay(fd int, nodelay bool) error {
 	if nodelay {
 		return syscall.SetsockoptInt(
@@ -42,13 +49,54 @@ func SetNoDelay(fd int, nodelay bool) error {
 	)
 }
 
+// SetKeepAlive 打开或者关闭SO_KEEPALIVE
+func SetKeepAlive(fd int, keepalive bool) error {
+	return syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, boolToInt(keepalive))
+}
+
+// SetKeepAliveIdle 连接空闲多久之后开始发送探测包, 精度是秒
+func SetKeepAliveIdle(fd int, d time.Duration) error {
+	return unix.SetsockoptInt(fd, unix.IPPROTO_TCP, tcpKeepIdle, keepAliveSeconds(d))
+}
+
+// SetKeepAliveInterval 两次探测包之间的间隔, 精度是秒
+func SetKeepAliveInterval(fd int, d time.Duration) error {
+	return unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_KEEPINTVL, keepAliveSeconds(d))
+}
+
+// SetKeepAliveCount 连续多少个探测包没有回应之后认为连接已经断开
+func SetKeepAliveCount(fd int, count int) error {
+	return unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_KEEPCNT, count)
+}
+
+// keepAliveSeconds 向上取整到秒, 最少1秒
+func keepAliveSeconds(d time.Duration) int {
+	secs := int((d + time.Second - 1) / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	return secs
+}
+
 const (
-	EAGAIN = syscall.EAGAIN
-	EINTR  = syscall.EINTR
+	EAGAIN       = syscall.EAGAIN
+	EINTR        = syscall.EINTR
+	ECONNABORTED = syscall.ECONNABORTED
+	EMFILE       = syscall.EMFILE
+	ENFILE       = syscall.ENFILE
 )
 
 // 复制一份socket
 func GetFdFromConn(c net.Conn) (newFd int, err error) {
+	return getFdFromSyscallConn(c)
+}
+
+// 复制一份监听socket
+func GetFdFromListener(l net.Listener) (newFd int, err error) {
+	return getFdFromSyscallConn(l)
+}
+
+func getFdFromSyscallConn(c any) (newFd int, err error) {
 	sc, ok := c.(interface {
 		SyscallConn() (syscall.RawConn, error)
 	})
@@ -70,8 +118,38 @@ func GetFdFromConn(c net.Conn) (newFd int, err error) {
 	return duplicateSocket(int(newFd))
 }
 
+// 复制出来的fd要带上close-on-exec, 不然会泄露给子进程(比如热重启的新进程),
+// 导致当前进程关闭连接的时候对端收不到FIN
 func duplicateSocket(socketFD int) (int, error) {
-	return unix.Dup(socketFD)
+	return unix.FcntlInt(uintptr(socketFD), unix.F_DUPFD_CLOEXEC, 0)
+}
+
+// SockaddrToAddr 把stream socket的地址转成net.Addr, 不认识的地址返回nil
+func SockaddrToAddr(sa unix.Sockaddr) net.Addr {
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		return &net.TCPAddr{IP: append(net.IP(nil), sa.Addr[:]...), Port: sa.Port}
+	case *unix.SockaddrInet6:
+		addr := &net.TCPAddr{IP: append(net.IP(nil), sa.Addr[:]...), Port: sa.Port}
+		if sa.ZoneId != 0 {
+			if ifi, err := net.InterfaceByIndex(int(sa.ZoneId)); err == nil {
+				addr.Zone = ifi.Name
+			}
+		}
+		return addr
+	case *unix.SockaddrUnix:
+		return &net.UnixAddr{Name: sa.Name, Net: "unix"}
+	}
+	return nil
+}
+
+// GetLocalAddr 通过getsockname获取socket的本地地址, 出错的时候返回nil
+func GetLocalAddr(fd int) net.Addr {
+	sa, err := unix.Getsockname(fd)
+	if err != nil {
+		return nil
+	}
+	return SockaddrToAddr(sa)
 }
 
 func GetSendBufferSize(fd int) (int, error) {
@@ -81,3 +159,111 @@ func GetSendBufferSize(fd int) (int, error) {
 	}
 	return size, nil
 }
+
+// SetSendBufferSize 设置SO_SNDBUF, linux下GetSendBufferSize读到的是设置值的两倍
+func SetSendBufferSize(fd int, size int) error {
+	return syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUF, size)
+}
+
+func GetRecvBufferSize(fd int) (int, error) {
+	return syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+}
+
+// SetRecvBufferSize 设置SO_RCVBUF, 需要在建立连接之前设置才会影响窗口扩大因子
+func SetRecvBufferSize(fd int, size int) error {
+	return syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, size)
+}
+
+// SetLinger 设置SO_LINGER, sec < 0关闭linger(默认行为), sec == 0的时候close直接发RST
+// sec > 0的时候close会阻塞到数据发送完或者超时, 会卡住event loop, 不建议使用
+func SetLinger(fd int, sec int) error {
+	l := syscall.Linger{}
+	if sec >= 0 {
+		l.Onoff = 1
+		l.Linger = int32(sec)
+	}
+	return syscall.SetsockoptLinger(fd, syscall.SOL_SOCKET, syscall.SO_LINGER, &l)
+}
+
+// GetLinger 返回SO_LINGER的秒数, 没有打开的时候返回-1
+func GetLinger(fd int) (int, error) {
+	l, err := unix.GetsockoptLinger(fd, unix.SOL_SOCKET, unix.SO_LINGER)
+	if err != nil {
+		return 0, err
+	}
+	if l.Onoff == 0 {
+		return -1, nil
+	}
+	return int(l.Linger), nil
+}
+
+// SetCork 打开的时候内核攒满一个包再发送, linux是TCP_CORK, bsd是TCP_NOPUSH
+// 关闭的时候会把攒着的数据立即发出去
+func SetCork(fd int, cork bool) error {
+	return unix.SetsockoptInt(fd, unix.IPPROTO_TCP, tcpCork, boolToInt(cork))
+}
+
+func GetCork(fd int) (bool, error) {
+	v, err := unix.GetsockoptInt(fd, unix.IPPROTO_TCP, tcpCork)
+	return v != 0, err
+}
+
+// SetTOS 设置IP_TOS, ipv6的socket设置的是IPV6_TCLASS
+func SetTOS(fd int, tos int) error {
+	level, opt := tosOption(fd)
+	return unix.SetsockoptInt(fd, level, opt, tos)
+}
+
+func GetTOS(fd int) (int, error) {
+	level, opt := tosOption(fd)
+	return unix.GetsockoptInt(fd, level, opt)
+}
+
+func tosOption(fd int) (level, opt int) {
+	if sa, err := unix.Getsockname(fd); err == nil {
+		if _, ok := sa.(*unix.SockaddrInet6); ok {
+			return unix.IPPROTO_IPV6, unix.IPV6_TCLASS
+		}
+	}
+	return unix.IPPROTO_IP, unix.IP_TOS
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// ListenReusePort 创建一个设置了SO_REUSEADDR和SO_REUSEPORT的非阻塞监听socket
+// 返回复制出来的fd和实际监听的地址(addr端口是0的时候，后面的socket需要用这个地址)
+func ListenReusePort(network, addr string) (fd int, laddr net.Addr, err error) {
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var opErr error
+			err := c.Control(func(fd uintptr) {
+				if opErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); opErr != nil {
+					return
+				}
+				opErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+			})
+			if err != nil {
+				return err
+			}
+			return opErr
+		},
+	}
+
+	l, err := lc.Listen(context.Background(), network, addr)
+	if err != nil {
+		return -1, nil, err
+	}
+	// 复制出来的fd和原fd共享O_NONBLOCK, 关闭原listener之后socket依然在监听
+	defer l.Close()
+
+	fd, err = GetFdFromListener(l)
+	if err != nil {
+		return -1, nil, err
+	}
+	return fd, l.Addr(), nil
+}
@@ -3,9 +3,11 @@
 package core
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"syscall"
+	"time"
 	"unsafe"
 )
 
@@ -23,14 +25,70 @@ func Close(fd int) error {
 
 const (
 	// TODO 瞎写的值，为了windows下面编译通过
-	EAGAIN = syscall.Errno(0x23)
-	EINTR  = syscall.Errno(0x24)
+	EAGAIN       = syscall.Errno(0x23)
+	EINTR        = syscall.Errno(0x24)
+	ECONNABORTED = syscall.Errno(0x25)
+	EMFILE       = syscall.Errno(0x26)
+	ENFILE       = syscall.Errno(0x27)
 )
 
+// Writev 用WSASend一次提交多个buffer
+func Writev(fd int, bufs [][]byte) (n int, err error) {
+	wsabufs := make([]syscall.WSABuf, 0, len(bufs))
+	for _, b := range bufs {
+		if len(b) == 0 {
+			continue
+		}
+		wsabufs = append(wsabufs, syscall.WSABuf{Len: uint32(len(b)), Buf: &b[0]})
+	}
+	if len(wsabufs) == 0 {
+		return 0, nil
+	}
+	var sent uint32
+	err = syscall.WSASend(syscall.Handle(fd), &wsabufs[0], uint32(len(wsabufs)), &sent, 0, nil, nil)
+	return int(sent), err
+}
+
+// SendFileSupported windows上没有实现sendfile(TransmitFile), 调用方需要自己读文件再写
+const SendFileSupported = false
+
+func SendFile(dst, src int, offset int64, count int) (n int, err error) {
+	return 0, errors.ErrUnsupported
+}
+
+func Dup(fd int) (int, error) {
+	return -1, errors.ErrUnsupported
+}
+
+func CloseWrite(fd int) error {
+	return syscall.Shutdown(syscall.Handle(fd), syscall.SHUT_WR)
+}
+
 func SetNoDelay(fd int, nodelay bool) error {
 	return nil
 }
 
+func SetKeepAlive(fd int, keepalive bool) error {
+	v := 0
+	if keepalive {
+		v = 1
+	}
+	return syscall.SetsockoptInt(syscall.Handle(fd), syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, v)
+}
+
+// TODO windows需要用SIO_KEEPALIVE_VALS设置, 没有实现之前返回ErrUnsupported, 不能假装设置成功了
+func SetKeepAliveIdle(fd int, d time.Duration) error {
+	return errors.ErrUnsupported
+}
+
+func SetKeepAliveInterval(fd int, d time.Duration) error {
+	return errors.ErrUnsupported
+}
+
+func SetKeepAliveCount(fd int, count int) error {
+	return errors.ErrUnsupported
+}
+
 func GetFdFromConn(conn net.Conn) (fd int, err error) {
 	// 类型断言为 *net.TCPConn 或其他具体类型
 	tcpConn, ok := conn.(*net.TCPConn)
@@ -49,24 +107,98 @@ func GetFdFromConn(conn net.Conn) (fd int, err error) {
 	return int(file.Fd()), nil
 }
 
+func ListenReusePort(network, addr string) (fd int, laddr net.Addr, err error) {
+	return -1, nil, errors.New("SO_REUSEPORT unsupported")
+}
+
+func GetLocalAddr(fd int) net.Addr {
+	return nil
+}
+
+func AcceptNonblock(fd int) (nfd int, raddr net.Addr, err error) {
+	return -1, nil, errors.New("AcceptNonblock unsupported")
+}
+
 func GetSendBufferSize(fd int) (int, error) {
+	return getsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+}
+
+func getsockoptInt(fd int, level, opt int32) (int, error) {
 	// Convert fd to windows Handle
 	handle := syscall.Handle(fd)
 
-	// Use getsockopt to get send buffer size
-	var size int32
+	// Use getsockopt to get the option value
+	var value int32
 	var length int32 = 4 // size of int32
 
-	// Call getsockopt with SOL_SOCKET and SO_SNDBUF
 	err := syscall.Getsockopt(
 		handle,
-		syscall.SOL_SOCKET,
-		syscall.SO_SNDBUF,
-		(*byte)(unsafe.Pointer(&size)),
+		level,
+		opt,
+		(*byte)(unsafe.Pointer(&value)),
 		&length,
 	)
 	if err != nil {
 		return 0, err
 	}
-	return int(size), nil
+	return int(value), nil
+}
+
+func SetSendBufferSize(fd int, size int) error {
+	return syscall.SetsockoptInt(syscall.Handle(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF, size)
+}
+
+func GetRecvBufferSize(fd int) (int, error) {
+	return getsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+}
+
+func SetRecvBufferSize(fd int, size int) error {
+	return syscall.SetsockoptInt(syscall.Handle(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF, size)
+}
+
+func SetLinger(fd int, sec int) error {
+	l := syscall.Linger{}
+	if sec >= 0 {
+		l.Onoff = 1
+		l.Linger = int32(sec)
+	}
+	return syscall.SetsockoptLinger(syscall.Handle(fd), syscall.SOL_SOCKET, syscall.SO_LINGER, &l)
+}
+
+// TODO windows下面的linger结构体是两个uint16, 先不支持读取
+func GetLinger(fd int) (int, error) {
+	return 0, errors.ErrUnsupported
+}
+
+func SetTCPUserTimeout(fd int, d time.Duration) error {
+	return errors.ErrUnsupported
+}
+
+func GetTCPUserTimeout(fd int) (time.Duration, error) {
+	return 0, errors.ErrUnsupported
+}
+
+func SetQuickAck(fd int, quickack bool) error {
+	return errors.ErrUnsupported
+}
+
+func GetQuickAck(fd int) (bool, error) {
+	return false, errors.ErrUnsupported
+}
+
+func SetCork(fd int, cork bool) error {
+	return errors.ErrUnsupported
+}
+
+func GetCork(fd int) (bool, error) {
+	return false, errors.ErrUnsupported
+}
+
+// windows需要用QoS API设置, 不支持IP_TOS
+func SetTOS(fd int, tos int) error {
+	return errors.ErrUnsupported
+}
+
+func GetTOS(fd int) (int, error) {
+	return 0, errors.ErrUnsupported
 }
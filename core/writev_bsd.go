@@ -0,0 +1,17 @@
+//go:build darwin || freebsd
+
+package core
+
+import "golang.org/x/sys/unix"
+
+// maxIovecs IOV_MAX, 超过的部分留给下一次调用
+const maxIovecs = 1024
+
+// Writev 一次系统调用写入多个buffer, 返回写入的总字节数
+// bsd没有封装writev, 用sendmsg代替
+func Writev(fd int, bufs [][]byte) (n int, err error) {
+	if len(bufs) > maxIovecs {
+		bufs = bufs[:maxIovecs]
+	}
+	return unix.SendmsgBuffers(fd, bufs, nil, nil, 0)
+}
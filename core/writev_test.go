@@ -0,0 +1,40 @@
+//go:build !windows
+
+package core
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestWritev(t *testing.T) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatalf("Socketpair() error = %v", err)
+	}
+	defer unix.Close(fds[0])
+	defer unix.Close(fds[1])
+
+	bufs := [][]byte{[]byte("header\r\n"), nil, []byte("body")}
+	n, err := Writev(fds[0], bufs)
+	if err != nil || n != 12 {
+		t.Fatalf("Writev() = %d, %v, want 12", n, err)
+	}
+	got := make([]byte, 32)
+	n, err = unix.Read(fds[1], got)
+	if err != nil || !bytes.Equal(got[:n], []byte("header\r\nbody")) {
+		t.Errorf("Read() = %q, %v, want header\\r\\nbody", got[:n], err)
+	}
+
+	// 超过IOV_MAX的时候只写前面的部分
+	many := make([][]byte, maxIovecs+10)
+	for i := range many {
+		many[i] = []byte{'x'}
+	}
+	n, err = Writev(fds[0], many)
+	if err != nil || n != maxIovecs {
+		t.Errorf("Writev() = %d, %v, want %d", n, err, maxIovecs)
+	}
+}
@@ -0,0 +1,283 @@
+package pulse
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/antlabs/pulse/core"
+)
+
+// eventLoop 在core.PollingApi的基础上加上每个event loop自己的状态
+type eventLoop struct {
+	core.PollingApi
+	id        int             // 第几个event loop
+	tasks     taskQueue       // 需要在event loop协程里执行的任务
+	listeners []*loopListener // 在event loop里面accept的监听socket(SO_REUSEPORT模式), 只在event loop协程里访问
+	connCount atomic.Int64    // 当前存活的连接数, 负载均衡使用
+	wheel     timingWheel     // 定时器, 其他协程可以直接添加和删除
+	goid      int64           // 运行event loop的协程id, 启动的时候设置
+	busy      bool            // 不在Poll里等待, 只在event loop协程里访问
+}
+
+// loopGoids 所有正在运行的event loop协程的id
+var loopGoids sync.Map
+
+// busyLoops 不在Poll里等待的event loop个数, 调用者是event loop协程的时候一定不为0
+var busyLoops atomic.Int32
+
+func newEventLoop(id int, poller core.PollingApi) *eventLoop {
+	return &eventLoop{id: id, PollingApi: poller}
+}
+
+// run 在event loop协程开始的时候调用, 记下协程id
+func (l *eventLoop) run() {
+	l.goid = curGoid()
+	loopGoids.Store(l.goid, l)
+	l.setBusy(true)
+}
+
+// setBusy 进入Poll之前设置为false, Poll返回之后(包括第一个事件的回调里)设置为true
+func (l *eventLoop) setBusy(busy bool) {
+	if l.busy == busy {
+		return
+	}
+	l.busy = busy
+	if busy {
+		busyLoops.Add(1)
+	} else {
+		busyLoops.Add(-1)
+	}
+}
+
+// onEventLoop 调用者是不是某个event loop协程(不只是连接所属的那个)
+// 所有event loop都在Poll里等待的时候调用者一定不是, 不需要解析调用栈
+func onEventLoop() bool {
+	if busyLoops.Load() == 0 {
+		return false
+	}
+	_, ok := loopGoids.Load(curGoid())
+	return ok
+}
+
+// curGoid 当前协程的id, 从runtime.Stack的第一行"goroutine 18 [running]:"里解析
+func curGoid() int64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	var id int64
+	for _, ch := range b {
+		if ch < '0' || ch > '9' {
+			break
+		}
+		id = id*10 + int64(ch-'0')
+	}
+	return id
+}
+
+// listener fd是监听socket的时候返回对应的loopListener
+func (l *eventLoop) listener(fd int) *loopListener {
+	for _, ln := range l.listeners {
+		if ln.fd == fd {
+			return ln
+		}
+	}
+	return nil
+}
+
+// post 把任务放到event loop里执行, 必要的时候唤醒Poll
+func (l *eventLoop) post(fn func()) error {
+	needWake, err := l.tasks.push(fn)
+	if err != nil {
+		return err
+	}
+	if needWake {
+		if err := l.Wake(); err != nil {
+			slog.Error("wake event loop", "err", err)
+		}
+	}
+	return nil
+}
+
+// runTasks 在event loop协程里执行所有排队的任务, 每次Poll返回之后调用
+func (l *eventLoop) runTasks() {
+	tasks := l.tasks.swap()
+	for i, fn := range tasks {
+		fn()
+		tasks[i] = nil
+	}
+	l.tasks.spare = tasks
+}
+
+// pollTimeout Poll的超时时间, 有定时器的时候要按时醒来
+func (l *eventLoop) pollTimeout() time.Duration {
+	return l.wheel.timeout(time.Now().UnixNano())
+}
+
+// runTimers 执行到期的定时器, 每次Poll返回之后调用
+func (l *eventLoop) runTimers() {
+	l.wheel.advance(time.Now().UnixNano())
+}
+
+// stop event loop退出的时候调用, 执行剩下的任务, 之后的post都会返回ErrServerClosed
+// 之后加的定时器不会执行, 也不再唤醒已经退出的Poll
+func (l *eventLoop) stop() {
+	l.wheel.stop()
+	for _, fn := range l.tasks.close() {
+		fn()
+	}
+	loopGoids.Delete(l.goid)
+	l.setBusy(false)
+}
+
+// taskQueue 多生产者单消费者的任务队列
+// 生产者是任意协程，消费者是event loop协程
+type taskQueue struct {
+	mu       sync.Mutex
+	tasks    []func()
+	spare    []func() // 交换用, 减少内存分配, 只在event loop协程里访问
+	closed   bool
+	notified atomic.Bool // 已经唤醒过event loop, 还没有被消费
+}
+
+// push 返回是否需要唤醒event loop
+func (q *taskQueue) push(fn func()) (needWake bool, err error) {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return false, ErrServerClosed
+	}
+	q.tasks = append(q.tasks, fn)
+	q.mu.Unlock()
+	return q.notified.CompareAndSwap(false, true), nil
+}
+
+// swap 取出所有任务, 只能在event loop协程里调用
+func (q *taskQueue) swap() []func() {
+	// 先清除标记，再取任务，保证新的任务一定能唤醒event loop
+	q.notified.Store(false)
+	q.mu.Lock()
+	tasks := q.tasks
+	q.tasks = q.spare[:0]
+	q.mu.Unlock()
+	q.spare = nil
+	return tasks
+}
+
+func (q *taskQueue) close() []func() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	tasks := q.tasks
+	q.tasks = nil
+	return tasks
+}
+
+// loopListener event loop自己持有的监听socket
+type loopListener struct {
+	eventLoop   *eventLoop
+	fd          int
+	addr        net.Addr
+	callback    Callback      // listener单独设置的回调, 可以为nil
+	acceptDelay time.Duration // accept出错之后的退避时间, 只在event loop协程里使用
+	closeOnce   sync.Once
+}
+
+// register 在event loop协程里注册监听socket, 等待注册完成
+func (l *loopListener) register() error {
+	errCh := make(chan error, 1)
+	if err := l.eventLoop.post(func() {
+		if err := l.eventLoop.AddRead(l.fd); err != nil {
+			errCh <- err
+			return
+		}
+		l.eventLoop.listeners = append(l.eventLoop.listeners, l)
+		errCh <- nil
+	}); err != nil {
+		return err
+	}
+	return <-errCh
+}
+
+// localAddr 新连接的本地地址, 监听的是具体地址的时候直接使用, 不需要getsockname
+func (l *loopListener) localAddr(fd int) net.Addr {
+	switch addr := l.addr.(type) {
+	case *net.TCPAddr:
+		if !addr.IP.IsUnspecified() {
+			return addr
+		}
+	case *net.UnixAddr:
+		return addr
+	}
+	return core.GetLocalAddr(fd)
+}
+
+// pause accept出错之后暂时不监听可读事件, d之后再恢复, 避免水平触发的时候空转
+// 在event loop协程里调用
+func (l *loopListener) pause(d time.Duration) {
+	if err := l.eventLoop.Del(l.fd); err != nil {
+		slog.Debug("pause listen fd", "err", err)
+	}
+	newTimer(l.eventLoop, d, func() {
+		// 暂停期间已经被关闭了
+		if !slices.Contains(l.eventLoop.listeners, l) {
+			return
+		}
+		if err := l.eventLoop.AddRead(l.fd); err != nil {
+			slog.Error("resume listen fd", "err", err)
+		}
+	})
+}
+
+// Close 在event loop协程里关闭监听socket, 避免fd被复用之后还被当成监听socket
+func (l *loopListener) Close() (err error) {
+	l.closeOnce.Do(func() {
+		err = l.eventLoop.post(func() {
+			listeners := l.eventLoop.listeners[:0]
+			for _, ln := range l.eventLoop.listeners {
+				if ln != l {
+					listeners = append(listeners, ln)
+				}
+			}
+			l.eventLoop.listeners = listeners
+			if err := l.eventLoop.Del(l.fd); err != nil {
+				slog.Debug("del listen fd", "err", err)
+			}
+			if err := core.Close(l.fd); err != nil {
+				slog.Error("close listen fd", "err", err)
+			}
+		})
+		if err != nil {
+			// event loop已经退出了, 直接关闭
+			err = core.Close(l.fd)
+		}
+	})
+	return err
+}
+
+type loopListeners []*loopListener
+
+func (ls loopListeners) Close() error {
+	var err error
+	for _, l := range ls {
+		err = errors.Join(err, l.Close())
+	}
+	return err
+}
+
+// closeNow 还没有交给event loop的时候直接关闭
+func (ls loopListeners) closeNow() {
+	for _, l := range ls {
+		l.closeOnce.Do(func() {
+			if err := core.Close(l.fd); err != nil {
+				slog.Error("close listen fd", "err", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package pulse
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/antlabs/pulse/core"
+)
+
+func TestTaskQueue(t *testing.T) {
+	var q taskQueue
+
+	needWake, err := q.push(func() {})
+	if err != nil || !needWake {
+		t.Fatalf("first push() = %v, %v, want true, nil", needWake, err)
+	}
+	// 还没有消费, 不需要重复唤醒
+	needWake, err = q.push(func() {})
+	if err != nil || needWake {
+		t.Fatalf("second push() = %v, %v, want false, nil", needWake, err)
+	}
+
+	if tasks := q.swap(); len(tasks) != 2 {
+		t.Fatalf("swap() got %d tasks, want 2", len(tasks))
+	}
+
+	// 消费之后，需要重新唤醒
+	needWake, _ = q.push(func() {})
+	if !needWake {
+		t.Error("push() after swap should need wake")
+	}
+
+	if tasks := q.close(); len(tasks) != 1 {
+		t.Errorf("close() got %d tasks, want 1", len(tasks))
+	}
+	if _, err := q.push(func() {}); !errors.Is(err, ErrServerClosed) {
+		t.Errorf("push() after close error = %v, want %v", err, ErrServerClosed)
+	}
+}
+
+func TestEventLoop_Post(t *testing.T) {
+	poller, err := core.Create(core.TriggerTypeLevel)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	defer poller.Free()
+	l := newEventLoop(0, poller)
+
+	var count int32
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for atomic.LoadInt32(&count) < 100 {
+			if _, err := l.Poll(0, func(int, core.State, error) {}); err != nil {
+				t.Errorf("Poll() error = %v", err)
+				return
+			}
+			l.runTasks()
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		go func() {
+			if err := l.post(func() { atomic.AddInt32(&count, 1) }); err != nil {
+				t.Errorf("post() error = %v", err)
+			}
+		}()
+	}
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timeout, only %d tasks executed", atomic.LoadInt32(&count))
+	}
+}
+
+type executeCallback struct {
+	executed chan bool
+}
+
+func (e *executeCallback) OnOpen(c *Conn) {}
+
+func (e *executeCallback) OnData(c *Conn, data []byte) {
+	// 业务协程里把任务交给event loop
+	if err := c.Execute(func() {
+		_, err := c.Write(data)
+		e.executed <- err == nil
+	}); err != nil {
+		e.executed <- false
+	}
+}
+
+func (e *executeCallback) OnClose(c *Conn, err error) {}
+
+func TestConn_Execute(t *testing.T) {
+	cb := &executeCallback{executed: make(chan bool, 1)}
+	el, err := NewMultiEventLoop(context.Background(), WithCallback(cb), WithTaskType(TaskTypeInBusinessGoroutine))
+	if err != nil {
+		t.Fatalf("NewMultiEventLoop() error = %v", err)
+	}
+	defer el.Free()
+
+	addr := getFreeAddr(t)
+	go func() {
+		_ = el.ListenAndServe(addr)
+	}()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = el.Shutdown(ctx)
+	}()
+
+	client := dialRetry(t, "tcp", addr)
+	defer client.Close()
+
+	if _, err := client.Write([]byte("hello")); err != nil {
+		t.Fatalf("client Write() error = %v", err)
+	}
+
+	select {
+	case ok := <-cb.executed:
+		if !ok {
+			t.Fatal("Execute task failed")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for Execute")
+	}
+
+	buf := make([]byte, 5)
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := client.Read(buf); err != nil || string(buf) != "hello" {
+		t.Errorf("client Read() = %q, %v, want hello", buf, err)
+	}
+}
+
+func TestMultiEventLoop_Post(t *testing.T) {
+	el, err := NewMultiEventLoop(context.Background(), WithCallback(&testCallback{}))
+	if err != nil {
+		t.Fatalf("NewMultiEventLoop() error = %v", err)
+	}
+	defer el.Free()
+
+	addr := getFreeAddr(t)
+	go func() {
+		_ = el.ListenAndServe(addr)
+	}()
+
+	// event loop还没启动也可以Post, 启动之后执行
+	done := make(chan struct{})
+	if err := el.Post(func() { close(done) }); err != nil {
+		t.Fatalf("Post() error = %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for Post")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := el.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	if err := el.Post(func() {}); !errors.Is(err, ErrServerClosed) {
+		t.Errorf("Post() after Shutdown error = %v, want %v", err, ErrServerClosed)
+	}
+}
@@ -0,0 +1,255 @@
+//go:build !windows
+
+package pulse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/antlabs/pulse/core"
+	"golang.org/x/sys/unix"
+)
+
+// 热重启的时候, 新进程通过这个环境变量找到交接监听socket的fd(socketpair的一端, 通过ExtraFiles继承)
+const envRestartFd = "PULSE_RESTART_FD"
+
+// 一次最多交接的监听socket个数, linux的SCM_MAX_FD
+const maxRestartFds = 253
+
+// HotRestart 零停机热重启
+// 1.启动新进程cmd(为nil时使用当前的可执行文件和参数)
+// 2.通过socketpair(SCM_RIGHTS)把所有监听socket交给新进程, 新进程使用InheritedListeners拿到这些socket
+// socketpair的一端通过cmd.ExtraFiles只传给新进程, 其他进程拿不到监听socket
+// SO_REUSEPORT模式下每个event loop的监听socket都会交接, 新进程需要全部Serve, 不然这些socket上排队的连接没人accept
+// 3.新进程收到之后, 当前进程停止accept, 等待已有连接的写缓冲区发送完(直到ctx超时), 然后关闭
+// 返回之后当前进程可以退出了, cmd由HotRestart负责Wait, 调用者不要再调用cmd.Wait
+func (e *MultiEventLoop) HotRestart(ctx context.Context, cmd *exec.Cmd) error {
+	if cmd == nil {
+		path, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		cmd = exec.Command(path, os.Args[1:]...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+	}
+
+	fds, unixListeners, err := e.listenerFds()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, fd := range fds {
+			_ = core.Close(fd)
+		}
+	}()
+
+	// macOS没有SOCK_CLOEXEC, 和标准库一样在ForkLock里设置close-on-exec
+	syscall.ForkLock.RLock()
+	pair, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	if err == nil {
+		unix.CloseOnExec(pair[0])
+		unix.CloseOnExec(pair[1])
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return err
+	}
+	childFile := os.NewFile(uintptr(pair[1]), "pulse-restart-child")
+	connFile := os.NewFile(uintptr(pair[0]), "pulse-restart")
+	fc, err := net.FileConn(connFile)
+	_ = connFile.Close()
+	if err != nil {
+		_ = childFile.Close()
+		return err
+	}
+	conn := fc.(*net.UnixConn)
+	defer conn.Close()
+
+	// ExtraFiles里的第i个文件在新进程里是fd 3+i
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, envRestartFd+"="+strconv.Itoa(3+len(cmd.ExtraFiles)))
+	cmd.ExtraFiles = append(cmd.ExtraFiles, childFile)
+	err = cmd.Start()
+	// 新进程已经继承了, 当前进程的这一端要关闭, 新进程退出的时候才能读到EOF
+	_ = childFile.Close()
+	if err != nil {
+		return err
+	}
+
+	// 新进程在交接之前退出了
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	handover := make(chan error, 1)
+	go func() {
+		handover <- sendListenerFds(conn, fds)
+	}()
+
+	select {
+	case err = <-handover:
+	case err = <-exited:
+		err = fmt.Errorf("pulse: new process exited before handover: %v", err)
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		_ = conn.Close()
+		_ = cmd.Process.Kill()
+		return err
+	}
+
+	// 新进程已经接管了, 关闭的时候不能删除socket文件
+	for _, ul := range unixListeners {
+		ul.SetUnlinkOnClose(false)
+	}
+	return e.Shutdown(ctx)
+}
+
+// listenerFds 复制一份所有监听socket的fd
+// unixListeners是unix socket的listener, 交接成功之后关闭的时候不能删除socket文件
+func (e *MultiEventLoop) listenerFds() (fds []int, unixListeners []*net.UnixListener, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, l := range e.listeners {
+		switch l := l.(type) {
+		case loopListeners:
+			// SO_REUSEPORT模式下每个event loop有自己的accept队列, 全部交接
+			// 只交接一个的话, 其他socket队列里还没accept的连接会在关闭的时候被内核reset
+			for _, ll := range l {
+				var fd int
+				if fd, err = unix.FcntlInt(uintptr(ll.fd), unix.F_DUPFD_CLOEXEC, 0); err != nil {
+					break
+				}
+				fds = append(fds, fd)
+			}
+		case *net.UnixListener:
+			var fd int
+			if fd, err = core.GetFdFromListener(l); err == nil {
+				fds = append(fds, fd)
+				unixListeners = append(unixListeners, l)
+			}
+		case net.Listener:
+			var fd int
+			if fd, err = core.GetFdFromListener(l); err == nil {
+				fds = append(fds, fd)
+			}
+		default:
+			err = fmt.Errorf("pulse: unsupported listener type %T", l)
+		}
+		if err != nil {
+			for _, fd := range fds {
+				_ = core.Close(fd)
+			}
+			return nil, nil, err
+		}
+	}
+
+	if len(fds) == 0 {
+		return nil, nil, errors.New("pulse: no listener to hand over")
+	}
+	if len(fds) > maxRestartFds {
+		for _, fd := range fds {
+			_ = core.Close(fd)
+		}
+		return nil, nil, fmt.Errorf("pulse: too many listeners %d", len(fds))
+	}
+	return fds, unixListeners, nil
+}
+
+// sendListenerFds 把fd发送给新进程, 然后等待新进程确认
+func sendListenerFds(conn *net.UnixConn, fds []int) error {
+	payload := []byte(strconv.Itoa(len(fds)))
+	if _, _, err := conn.WriteMsgUnix(payload, unix.UnixRights(fds...), nil); err != nil {
+		return err
+	}
+
+	var ack [1]byte
+	if _, err := io.ReadFull(conn, ack[:]); err != nil {
+		return fmt.Errorf("pulse: wait handover ack: %w", err)
+	}
+	return nil
+}
+
+// restartListeners 新进程从旧进程那里接收监听socket
+func restartListeners() ([]net.Listener, error) {
+	fd, err := strconv.Atoi(os.Getenv(envRestartFd))
+	_ = os.Unsetenv(envRestartFd)
+	if err != nil || fd < 3 {
+		return nil, fmt.Errorf("pulse: invalid %s", envRestartFd)
+	}
+
+	// 不再传给之后启动的子进程
+	unix.CloseOnExec(fd)
+	f := os.NewFile(uintptr(fd), "pulse-restart")
+	conn, err := net.FileConn(f)
+	_ = f.Close()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, fmt.Errorf("pulse: %s is not a unix socket", envRestartFd)
+	}
+	_ = uc.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	buf := make([]byte, 16)
+	oob := make([]byte, unix.CmsgSpace(maxRestartFds*4))
+	_, oobn, _, _, err := uc.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, err
+	}
+
+	var fds []int
+	for i := range msgs {
+		rights, err := unix.ParseUnixRights(&msgs[i])
+		if err != nil {
+			return nil, err
+		}
+		fds = append(fds, rights...)
+	}
+
+	listeners := make([]net.Listener, 0, len(fds))
+	for i, fd := range fds {
+		unix.CloseOnExec(fd)
+		f := os.NewFile(uintptr(fd), "pulse-restart-"+strconv.Itoa(i))
+		l, err := net.FileListener(f)
+		_ = f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+
+	// 通知旧进程可以停止accept了
+	if _, err := uc.Write([]byte{1}); err != nil {
+		for _, l := range listeners {
+			_ = l.Close()
+		}
+		return nil, err
+	}
+	return listeners, nil
+}
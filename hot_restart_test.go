@@ -0,0 +1,160 @@
+//go:build !windows
+
+package pulse
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const envHotRestartChild = "PULSE_TEST_HOT_RESTART_CHILD"
+
+// TestHotRestartChild 热重启测试里的新进程, 直接运行时跳过
+func TestHotRestartChild(t *testing.T) {
+	if os.Getenv(envHotRestartChild) != "1" {
+		t.Skip("only run as hot restart child process")
+	}
+
+	listeners, err := InheritedListeners()
+	if err != nil || len(listeners) == 0 {
+		t.Fatalf("InheritedListeners() = %v, %v", listeners, err)
+	}
+
+	// 回复里带上收到的监听socket个数
+	el, err := NewMultiEventLoop(context.Background(),
+		WithCallback(prefixCallback{prefix: fmt.Sprintf("child%d:", len(listeners))}),
+		WithTaskType(TaskTypeInEventLoop))
+	if err != nil {
+		t.Fatalf("NewMultiEventLoop() error = %v", err)
+	}
+	for _, l := range listeners {
+		l := l
+		go func() {
+			_ = el.Serve(l)
+		}()
+	}
+
+	// 父进程关闭stdin的时候退出
+	_, _ = io.Copy(io.Discard, os.Stdin)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_ = el.Shutdown(ctx)
+}
+
+func TestMultiEventLoop_HotRestart(t *testing.T) {
+	t.Run("listener", func(t *testing.T) {
+		testHotRestart(t, 1)
+	})
+	// SO_REUSEPORT模式下每个event loop的监听socket都要交给新进程
+	t.Run("reuseport", func(t *testing.T) {
+		testHotRestart(t, 3, WithReusePort(true), WithEventLoopCount(3))
+	})
+}
+
+func testHotRestart(t *testing.T, wantFds int, opts ...func(*Options)) {
+	el, err := NewMultiEventLoop(context.Background(), append([]func(*Options){
+		WithCallback(prefixCallback{prefix: "parent:"}),
+		WithTaskType(TaskTypeInEventLoop)}, opts...)...)
+	if err != nil {
+		t.Fatalf("NewMultiEventLoop() error = %v", err)
+	}
+	defer el.Free()
+
+	addr, err := el.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+
+	readReply := func(c net.Conn, msg string) string {
+		t.Helper()
+		if _, err := c.Write([]byte(msg)); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		reply, err := bufio.NewReader(c).ReadString('\n')
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+		return reply
+	}
+
+	old := dialRetry(t, "tcp", addr.String())
+	defer old.Close()
+	if reply := readReply(old, "hello\n"); reply != "parent:hello\n" {
+		t.Fatalf("reply before restart = %q", reply)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHotRestartChild$", "-test.count=1")
+	cmd.Env = append(os.Environ(), envHotRestartChild+"=1")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	// 调用者自己的ExtraFiles不受影响, 交接用的socketpair排在后面
+	cmd.ExtraFiles = []*os.File{os.Stderr}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatalf("StdinPipe() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := el.HotRestart(ctx, cmd); err != nil {
+		t.Fatalf("HotRestart() error = %v", err)
+	}
+	// 关闭stdin之后新进程退出
+	defer stdin.Close()
+
+	// 旧连接被优雅关闭
+	_ = old.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := old.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Errorf("old conn Read() error = %v, want %v", err, io.EOF)
+	}
+
+	// 新连接由新进程处理
+	c := dialRetry(t, "tcp", addr.String())
+	defer c.Close()
+	if reply, want := readReply(c, "hello\n"), fmt.Sprintf("child%d:", wantFds); !strings.HasPrefix(reply, want) {
+		t.Errorf("reply after restart = %q, want %s prefix", reply, want)
+	}
+
+	if _, err := el.Listen("tcp", "127.0.0.1:0"); !errors.Is(err, ErrServerClosed) {
+		t.Errorf("Listen() after restart error = %v, want %v", err, ErrServerClosed)
+	}
+}
+
+// 新进程没有接管的时候, 旧进程的unix socket文件在关闭的时候照常删除
+func TestMultiEventLoop_HotRestartFailed(t *testing.T) {
+	el, err := NewMultiEventLoop(context.Background(),
+		WithCallback(echoCallback{}),
+		WithTaskType(TaskTypeInEventLoop))
+	if err != nil {
+		t.Fatalf("NewMultiEventLoop() error = %v", err)
+	}
+	defer el.Free()
+
+	sock := filepath.Join(t.TempDir(), "admin.sock")
+	if _, err := el.Listen("unix", sock); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := el.HotRestart(ctx, exec.Command("false")); err == nil {
+		t.Fatal("HotRestart() with exiting child should fail")
+	}
+	if err := el.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	if _, err := os.Stat(sock); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Stat(%s) error = %v, want not exist", sock, err)
+	}
+}
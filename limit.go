@@ -0,0 +1,88 @@
+package pulse
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// connLimiter 限制总连接数和单个ip的连接数, 为nil的时候不限制
+type connLimiter struct {
+	maxConns int // 最大连接数, <=0不限制
+	maxPerIP int // 单个ip最大连接数, <=0不限制
+
+	total atomic.Int64
+	mu    sync.Mutex
+	perIP map[string]int
+}
+
+func newConnLimiter(maxConns, maxPerIP int) *connLimiter {
+	if maxConns <= 0 && maxPerIP <= 0 {
+		return nil
+	}
+	return &connLimiter{
+		maxConns: maxConns,
+		maxPerIP: maxPerIP,
+		perIP:    make(map[string]int),
+	}
+}
+
+// acquire 占用一个连接名额, 返回的key在连接关闭的时候传给release
+func (l *connLimiter) acquire(remoteAddr net.Addr) (key string, ok bool) {
+	if l == nil {
+		return "", true
+	}
+
+	if l.maxConns > 0 && l.total.Add(1) > int64(l.maxConns) {
+		l.total.Add(-1)
+		return "", false
+	}
+
+	if l.maxPerIP > 0 {
+		key = ipKey(remoteAddr)
+	}
+	if key != "" {
+		l.mu.Lock()
+		if l.perIP[key] >= l.maxPerIP {
+			l.mu.Unlock()
+			if l.maxConns > 0 {
+				l.total.Add(-1)
+			}
+			return "", false
+		}
+		l.perIP[key]++
+		l.mu.Unlock()
+	}
+	return key, true
+}
+
+func (l *connLimiter) release(key string) {
+	if l == nil {
+		return
+	}
+
+	if l.maxConns > 0 {
+		l.total.Add(-1)
+	}
+	if key == "" {
+		return
+	}
+	l.mu.Lock()
+	if n := l.perIP[key] - 1; n > 0 {
+		l.perIP[key] = n
+	} else {
+		delete(l.perIP, key)
+	}
+	l.mu.Unlock()
+}
+
+// ipKey 单个ip限制使用的key, unix socket等没有ip的地址返回空, 不做限制
+func ipKey(addr net.Addr) string {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.IP.String()
+	case *net.UDPAddr:
+		return addr.IP.String()
+	}
+	return ""
+}
@@ -0,0 +1,197 @@
+package pulse
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConnLimiter(t *testing.T) {
+	if l := newConnLimiter(0, 0); l != nil {
+		t.Fatal("newConnLimiter(0, 0) should be nil")
+	}
+
+	a1 := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1000}
+	a2 := &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 1000}
+	unixAddr := &net.UnixAddr{Name: "/tmp/a.sock", Net: "unix"}
+
+	l := newConnLimiter(3, 2)
+	k1, ok1 := l.acquire(a1)
+	k2, ok2 := l.acquire(a1)
+	if !ok1 || !ok2 {
+		t.Fatal("first two connections from the same ip should be accepted")
+	}
+	if _, ok := l.acquire(a1); ok {
+		t.Fatal("third connection from the same ip should be rejected")
+	}
+	k3, ok := l.acquire(a2)
+	if !ok {
+		t.Fatal("connection from another ip should be accepted")
+	}
+	if _, ok := l.acquire(unixAddr); ok {
+		t.Fatal("connection over max connections should be rejected")
+	}
+
+	l.release(k1)
+	if _, ok := l.acquire(unixAddr); !ok {
+		t.Fatal("unix connection should be accepted after release")
+	}
+	l.release(k2)
+	l.release(k3)
+	if n := len(l.perIP); n != 0 {
+		t.Errorf("perIP entries = %d, want 0", n)
+	}
+}
+
+func TestMultiEventLoop_Admission(t *testing.T) {
+	for _, reusePort := range []bool{false, true} {
+		var accepted atomic.Int64
+		el, err := NewMultiEventLoop(context.Background(),
+			WithCallback(echoCallback{}),
+			WithTaskType(TaskTypeInEventLoop),
+			WithReusePort(reusePort),
+			WithMaxConnectionsPerIP(2),
+			WithRejectResponse([]byte("busy\n")),
+			WithOnAccept(func(remoteAddr net.Addr) bool {
+				if _, ok := remoteAddr.(*net.TCPAddr); !ok {
+					t.Errorf("reusePort=%v: remoteAddr = %T, want *net.TCPAddr", reusePort, remoteAddr)
+				}
+				accepted.Add(1)
+				return true
+			}))
+		if err != nil {
+			t.Fatalf("NewMultiEventLoop() error = %v", err)
+		}
+		addr, err := el.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Listen() error = %v", err)
+		}
+
+		c1 := dialRetry(t, "tcp", addr.String())
+		echoRoundTrip(t, c1, "hello")
+		c2 := dialRetry(t, "tcp", addr.String())
+		echoRoundTrip(t, c2, "hello")
+
+		// 第三个连接超过限制, 收到拒绝响应之后被关闭
+		c3 := dialRetry(t, "tcp", addr.String())
+		_ = c3.SetReadDeadline(time.Now().Add(2 * time.Second))
+		data, err := io.ReadAll(c3)
+		if err != nil || string(data) != "busy\n" {
+			t.Errorf("reusePort=%v: rejected conn read = %q, %v, want busy", reusePort, data, err)
+		}
+		c3.Close()
+
+		// 关闭一个连接之后名额被释放
+		c1.Close()
+		var c4 net.Conn
+		for i := 0; i < 100; i++ {
+			c4 = dialRetry(t, "tcp", addr.String())
+			if _, err := c4.Write([]byte("again")); err == nil {
+				buf := make([]byte, len("again"))
+				_ = c4.SetReadDeadline(time.Now().Add(time.Second))
+				if _, err := io.ReadFull(c4, buf); err == nil && string(buf) == "again" {
+					break
+				}
+			}
+			c4.Close()
+			c4 = nil
+			time.Sleep(10 * time.Millisecond)
+		}
+		if c4 == nil {
+			t.Errorf("reusePort=%v: connection slot not released", reusePort)
+		} else {
+			c4.Close()
+		}
+		c2.Close()
+
+		if stats := el.AcceptStats(); stats.Rejected == 0 {
+			t.Errorf("reusePort=%v: AcceptStats().Rejected = 0", reusePort)
+		}
+		if accepted.Load() < 3 {
+			t.Errorf("reusePort=%v: OnAccept called %d times, want >= 3", reusePort, accepted.Load())
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		_ = el.Shutdown(ctx)
+		cancel()
+		el.Free()
+	}
+}
+
+func TestMultiEventLoop_OnAcceptReject(t *testing.T) {
+	opened := make(chan *Conn, 1)
+	el, err := NewMultiEventLoop(context.Background(),
+		WithCallback(ToCallback(func(c *Conn, err error) {
+			opened <- c
+		}, func(c *Conn, data []byte) {}, func(c *Conn, err error) {})),
+		WithTaskType(TaskTypeInEventLoop),
+		WithMaxConnections(1),
+		WithOnAccept(func(remoteAddr net.Addr) bool {
+			return false
+		}))
+	if err != nil {
+		t.Fatalf("NewMultiEventLoop() error = %v", err)
+	}
+	defer el.Free()
+	addr, err := el.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = el.Shutdown(ctx)
+	}()
+
+	for i := 0; i < 2; i++ {
+		c := dialRetry(t, "tcp", addr.String())
+		_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("Read() error = %v, want EOF", err)
+		}
+		c.Close()
+	}
+
+	select {
+	case <-opened:
+		t.Error("OnOpen should not be called for rejected connections")
+	default:
+	}
+	// OnAccept拒绝的时候要释放WithMaxConnections的名额
+	if n := el.limiter.total.Load(); n != 0 {
+		t.Errorf("limiter total = %d, want 0", n)
+	}
+}
+
+// 客户端连接没有经过admit, 关闭的时候不能释放名额
+func TestClientEventLoop_LimiterNotReleased(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() error = %v", err)
+	}
+	defer ln.Close()
+
+	r := &closeRecorder{opened: make(chan struct{}), closed: make(chan struct{})}
+	loop := NewClientEventLoop(context.Background(), WithCallback(r), WithMaxConnections(1))
+	defer loop.Free()
+
+	nc, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial() error = %v", err)
+	}
+	if err := loop.RegisterConn(nc); err != nil {
+		t.Fatalf("RegisterConn() error = %v", err)
+	}
+	<-r.opened
+	r.mu.Lock()
+	c := r.conn
+	r.mu.Unlock()
+	_ = c.Close()
+
+	if n := loop.limiter.total.Load(); n != 0 {
+		t.Errorf("limiter total = %d after closing client conn, want 0", n)
+	}
+}
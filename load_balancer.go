@@ -0,0 +1,79 @@
+package pulse
+
+import (
+	"hash/fnv"
+	"net"
+	"sync/atomic"
+)
+
+// LoadBalancer 新连接选择event loop的策略
+// loads是每个event loop当前的连接数, 返回选中的event loop下标
+// 可能被多个协程同时调用, 实现需要并发安全
+type LoadBalancer interface {
+	Select(remoteAddr net.Addr, loads []int64) int
+}
+
+// LoadBalancerFunc 工具函数, 普通函数转成LoadBalancer接口
+type LoadBalancerFunc func(remoteAddr net.Addr, loads []int64) int
+
+func (f LoadBalancerFunc) Select(remoteAddr net.Addr, loads []int64) int {
+	return f(remoteAddr, loads)
+}
+
+type roundRobin struct {
+	next atomic.Uint32
+}
+
+func (r *roundRobin) Select(remoteAddr net.Addr, loads []int64) int {
+	return int((r.next.Add(1) - 1) % uint32(len(loads)))
+}
+
+// RoundRobin 轮询分配(默认)
+func RoundRobin() LoadBalancer {
+	return &roundRobin{}
+}
+
+type leastConnections struct{}
+
+func (leastConnections) Select(remoteAddr net.Addr, loads []int64) int {
+	index := 0
+	for i, load := range loads {
+		if load < loads[index] {
+			index = i
+		}
+	}
+	return index
+}
+
+// LeastConnections 分配给当前连接数最少的event loop, 适合长连接并且连接存活时间差别大的场景
+func LeastConnections() LoadBalancer {
+	return leastConnections{}
+}
+
+type sourceIPHash struct{}
+
+func (sourceIPHash) Select(remoteAddr net.Addr, loads []int64) int {
+	if remoteAddr == nil {
+		return 0
+	}
+
+	var ip []byte
+	switch addr := remoteAddr.(type) {
+	case *net.TCPAddr:
+		ip = addr.IP
+	case *net.UDPAddr:
+		ip = addr.IP
+	default:
+		// unix socket等没有ip的地址, 使用整个地址
+		ip = []byte(remoteAddr.String())
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write(ip)
+	return int(h.Sum32() % uint32(len(loads)))
+}
+
+// SourceIPHash 根据客户端ip做hash, 同一个ip的连接总是分配到同一个event loop
+func SourceIPHash() LoadBalancer {
+	return sourceIPHash{}
+}
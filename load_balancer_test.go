@@ -0,0 +1,101 @@
+package pulse
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRoundRobin(t *testing.T) {
+	lb := RoundRobin()
+	loads := make([]int64, 3)
+	for i := 0; i < 6; i++ {
+		if got := lb.Select(nil, loads); got != i%3 {
+			t.Errorf("Select() #%d = %d, want %d", i, got, i%3)
+		}
+	}
+}
+
+func TestLeastConnections(t *testing.T) {
+	lb := LeastConnections()
+	tests := []struct {
+		loads []int64
+		want  int
+	}{
+		{loads: []int64{0, 0, 0}, want: 0},
+		{loads: []int64{3, 1, 2}, want: 1},
+		{loads: []int64{5, 5, 4}, want: 2},
+	}
+	for _, tt := range tests {
+		if got := lb.Select(nil, tt.loads); got != tt.want {
+			t.Errorf("Select(%v) = %d, want %d", tt.loads, got, tt.want)
+		}
+	}
+}
+
+func TestSourceIPHash(t *testing.T) {
+	lb := SourceIPHash()
+	loads := make([]int64, 8)
+
+	a1 := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1000}
+	a2 := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 2000}
+	if lb.Select(a1, loads) != lb.Select(a2, loads) {
+		t.Error("same ip with different port should select the same event loop")
+	}
+
+	seen := make(map[int]bool)
+	for i := 0; i < 64; i++ {
+		addr := &net.TCPAddr{IP: net.IPv4(10, 0, byte(i), 1), Port: 1000}
+		index := lb.Select(addr, loads)
+		if index < 0 || index >= len(loads) {
+			t.Fatalf("Select() = %d, out of range", index)
+		}
+		seen[index] = true
+	}
+	if len(seen) < 2 {
+		t.Error("different ips should spread over event loops")
+	}
+
+	if got := lb.Select(nil, loads); got != 0 {
+		t.Errorf("Select(nil) = %d, want 0", got)
+	}
+}
+
+func TestWithLoadBalancer(t *testing.T) {
+	el, err := NewMultiEventLoop(context.Background(),
+		WithCallback(echoCallback{}),
+		WithTaskType(TaskTypeInEventLoop),
+		WithEventLoopCount(2),
+		WithLoadBalancer(LoadBalancerFunc(func(remoteAddr net.Addr, loads []int64) int {
+			// 越界的下标会被取模
+			return len(loads)*2 + 1
+		})))
+	if err != nil {
+		t.Fatalf("NewMultiEventLoop() error = %v", err)
+	}
+	defer el.Free()
+
+	addr := getFreeAddr(t)
+	go func() {
+		_ = el.ListenAndServe(addr)
+	}()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = el.Shutdown(ctx)
+	}()
+
+	for i := 0; i < 3; i++ {
+		c := dialRetry(t, "tcp", addr)
+		defer c.Close()
+		echoRoundTrip(t, c, "hello")
+	}
+
+	if got := el.eventLoops[1].connCount.Load(); got != 3 {
+		t.Errorf("eventLoops[1] conns = %d, want 3", got)
+	}
+	if got := el.eventLoops[0].connCount.Load(); got != 0 {
+		t.Errorf("eventLoops[0] conns = %d, want 0", got)
+	}
+}
@@ -9,8 +9,11 @@ import (
 	"net"
 	"os"
 	"runtime"
+	"slices"
 	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/antlabs/pulse/core"
@@ -18,10 +21,33 @@ import (
 	"github.com/antlabs/task/task/driver"
 )
 
+// 调用Shutdown之后，ListenAndServe会返回这个错误, OnClose也会收到这个错误
+var ErrServerClosed = errors.New("pulse: server closed")
+
+// 等待写缓冲区清空的检查间隔
+const shutdownPollInterval = 10 * time.Millisecond
+
 type MultiEventLoop struct {
-	eventLoops []core.PollingApi
+	eventLoops []*eventLoop
 	options    Options
 	localTask  selectTasks
+	ctx        context.Context
+	safeConns  core.SafeConns[Conn]
+
+	mu         sync.Mutex
+	listeners  []io.Closer
+	started    bool           // event loop是否已经启动
+	inShutdown atomic.Bool    // 不再接收新连接
+	closed     atomic.Bool    // event loop需要退出
+	wg         sync.WaitGroup // 等待accept协程和event loop协程退出
+	done       chan struct{}  // Shutdown完成之后关闭
+	nextPost   uint32         // Post轮询计数器
+
+	reserveFd      *core.ReserveFd // fd耗尽的时候用来丢弃连接
+	limiter        *connLimiter    // 连接数限制, 没有设置的时候为nil
+	acceptErrors   atomic.Uint64
+	acceptShed     atomic.Uint64
+	acceptRejected atomic.Uint64
 }
 
 func (m *MultiEventLoop) initDefaultSetting() {
@@ -56,15 +82,27 @@ func (m *MultiEventLoop) initDefaultSetting() {
 		}
 		m.options.maxSocketReadTimes = defMaxSocketReadTimes
 	}
+
+	if m.options.eventLoopCount <= 0 {
+		m.options.eventLoopCount = runtime.NumCPU()
+	}
+
+	if m.options.loadBalancer == nil {
+		m.options.loadBalancer = RoundRobin()
+	}
+
+	if m.options.shutdownTimeout == 0 {
+		m.options.shutdownTimeout = defShutdownTimeout
+	}
 }
 
 func NewMultiEventLoop(ctx context.Context, options ...func(*Options)) (e *MultiEventLoop, err error) {
-	eventLoops := make([]core.PollingApi, runtime.NumCPU())
-
 	var c driver.Conf
 	c.Log = slog.Default()
 	e = &MultiEventLoop{
-		eventLoops: eventLoops,
+		ctx:       ctx,
+		done:      make(chan struct{}),
+		reserveFd: core.NewReserveFd(),
 	}
 
 	for _, option := range options {
@@ -72,147 +110,465 @@ func NewMultiEventLoop(ctx context.Context, options ...func(*Options)) (e *Multi
 	}
 
 	e.initDefaultSetting()
-	for i := 0; i < runtime.NumCPU(); i++ {
-		eventLoops[i], err = core.Create(e.options.triggerType)
+	e.eventLoops = make([]*eventLoop, e.options.eventLoopCount)
+	for i := range e.eventLoops {
+		poller, err := core.Create(e.options.triggerType)
 		if err != nil {
+			for _, eventLoop := range e.eventLoops[:i] {
+				eventLoop.Free()
+			}
+			_ = e.reserveFd.Close()
 			return nil, err
 		}
+		e.eventLoops[i] = newEventLoop(i, poller)
 	}
+	e.limiter = newConnLimiter(e.options.maxConnections, e.options.maxConnectionsPerIP)
 	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: e.options.level})))
 	e.localTask = newSelectTask(ctx, e.options.task.initCount, e.options.task.min, e.options.task.max, &c)
+	e.safeConns.Init(core.GetMaxFd())
+
+	// ctx被取消的时候，优雅关闭
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				shutdownCtx, cancel := context.WithTimeout(context.Background(), e.options.shutdownTimeout)
+				defer cancel()
+				if err := e.Shutdown(shutdownCtx); err != nil {
+					slog.Error("shutdown", "err", err)
+				}
+			case <-e.done:
+			}
+		}()
+	}
 
 	return e, nil
 }
 
+// ListenAndServe 监听tcp地址, 一直阻塞到Shutdown完成, 返回ErrServerClosed
 func (e *MultiEventLoop) ListenAndServe(addr string) error {
 	slog.Debug("listenAndServe", "addr", addr)
-	var safeConns core.SafeConns[Conn]
-	safeConns.Init(core.GetMaxFd())
-
-	l, err := net.Listen("tcp", addr)
-	if err != nil {
-		slog.Error("listen", "err", err)
+	if _, err := e.Listen("tcp", addr); err != nil {
 		return err
 	}
 
-	var wg sync.WaitGroup
-	wg.Add(1 + len(e.eventLoops))
-	defer wg.Wait()
-
 	// 暂时关闭，分析内存才会打开
 	// go func() {
-	// 	defer wg.Done()
 	// 	for {
 	// 		time.Sleep(time.Second * 1)
-	// 		DebugConns(&safeConns, 10000)
+	// 		DebugConns(&e.safeConns, 10000)
 	// 	}
 	// }()
-	go func() {
-		defer wg.Done()
-		// 统计每个eventLoop的连接数
-		count := make([]int, len(e.eventLoops))
-		for i := 0; ; i++ {
-			c, err := l.Accept()
-			if err != nil {
-				// TODO 优化
-				time.Sleep(time.Second * 1)
+	return e.wait()
+}
+
+// Listen 监听network(tcp, tcp4, tcp6, unix)地址, 不阻塞, 返回实际监听的地址
+// 可以多次调用, 所有listener共享同一组event loop
+// 之后调用ListenAndServe或者Serve阻塞
+func (e *MultiEventLoop) Listen(network, addr string, opts ...func(*ListenerOptions)) (net.Addr, error) {
+	if e.inShutdown.Load() {
+		return nil, ErrServerClosed
+	}
+
+	lo := newListenerOptions(opts)
+	if e.options.reusePort && strings.HasPrefix(network, "tcp") {
+		return e.listenReusePort(network, addr, lo)
+	}
+
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		slog.Error("listen", "err", err)
+		return nil, err
+	}
+
+	if err := e.serve(l, lo, nil); err != nil {
+		return nil, err
+	}
+	return l.Addr(), nil
+}
+
+// Serve 在已经存在的listener上accept, 一直阻塞到Shutdown完成, 返回ErrServerClosed
+// Shutdown的时候会关闭l. l被其他地方关闭的时候马上返回Accept的错误(net.ErrClosed)
+func (e *MultiEventLoop) Serve(l net.Listener, opts ...func(*ListenerOptions)) error {
+	closed := make(chan error, 1)
+	if err := e.serve(l, newListenerOptions(opts), closed); err != nil {
+		return err
+	}
+	select {
+	case err := <-closed:
+		return err
+	case <-e.done:
+		return ErrServerClosed
+	}
+}
+
+// serve 启动accept协程, l被其他地方关闭的时候把错误发给closed, closed可以为nil
+func (e *MultiEventLoop) serve(l net.Listener, lo *ListenerOptions, closed chan<- error) error {
+	if !e.trackListener(l, 1) {
+		return ErrServerClosed
+	}
+	go e.acceptLoop(l, lo.callback, closed)
+	return nil
+}
+
+// wait 等待Shutdown完成
+func (e *MultiEventLoop) wait() error {
+	<-e.done
+	return ErrServerClosed
+}
+
+// listenReusePort 每个event loop都有一个自己的SO_REUSEPORT监听socket
+// 由内核做负载均衡, 在event loop里面accept
+func (e *MultiEventLoop) listenReusePort(network, addr string, lo *ListenerOptions) (net.Addr, error) {
+	listeners := make(loopListeners, 0, len(e.eventLoops))
+	var laddr net.Addr
+	for _, eventLoop := range e.eventLoops {
+		fd, a, err := core.ListenReusePort(network, addr)
+		if err != nil {
+			slog.Error("listen reuseport", "err", err)
+			listeners.closeNow()
+			return nil, err
+		}
+		// 端口是0的时候, 后面的socket要监听同一个端口
+		laddr = a
+		addr = a.String()
+		listeners = append(listeners, &loopListener{eventLoop: eventLoop, fd: fd, addr: a, callback: lo.callback})
+	}
+
+	if !e.trackListener(listeners, 0) {
+		return nil, ErrServerClosed
+	}
+
+	for _, l := range listeners {
+		if err := l.register(); err != nil {
+			slog.Error("register listener", "err", err)
+			if closeErr := listeners.Close(); closeErr != nil {
+				slog.Error("close listener", "err", closeErr)
+			}
+			return nil, err
+		}
+	}
+	return laddr, nil
+}
+
+// acceptLoop 在单独的协程里accept, 然后把连接分配给event loop
+func (e *MultiEventLoop) acceptLoop(l net.Listener, callback Callback, closed chan<- error) {
+	defer e.wg.Done()
+	// 每个eventLoop的连接数, 只在accept协程里使用, 复用内存
+	loads := make([]int64, len(e.eventLoops))
+	var delay time.Duration
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if e.inShutdown.Load() {
+				return
+			}
+			// 不是Shutdown关闭的listener, 重试也没有意义
+			if errors.Is(err, net.ErrClosed) {
+				e.untrackListener(l)
+				if closed != nil {
+					closed <- err
+				}
+				return
+			}
+			e.acceptError(l.Addr(), err)
+			// fd耗尽的时候丢弃排队的连接, 让客户端尽快收到关闭, 而不是等到超时
+			if isFdExhausted(err) && e.shedListener(l) {
+				delay = 0
 				continue
 			}
+			delay = nextAcceptDelay(delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
 
-			fd, err := core.GetFdFromConn(c)
-			if err != nil {
-				slog.Error("getFdFromConn", "err", err)
+		// 在dup之前判断, 拒绝的连接不需要多余的系统调用
+		key, ok := e.admit(c.RemoteAddr())
+		if !ok {
+			e.rejectConn(c)
+			continue
+		}
+
+		fd, err := core.GetFdFromConn(c)
+		if closeErr := c.Close(); closeErr != nil {
+			log.Printf("failed to close connection: %v", closeErr)
+		}
+		if err != nil {
+			// dup失败的时候连接已经被上面关闭了
+			e.limiter.release(key)
+			e.acceptError(l.Addr(), err)
+			continue
+		}
+
+		index := e.selectEventLoopWithLoads(c.RemoteAddr(), loads)
+		e.addConn(fd, c.LocalAddr(), c.RemoteAddr(), e.eventLoops[index], callback, key)
+	}
+}
+
+// acceptInLoop 在event loop里面accept, 一直accept到EAGAIN, 水平触发和边缘触发都适用
+func (e *MultiEventLoop) acceptInLoop(l *loopListener) {
+	for {
+		fd, raddr, err := core.AcceptNonblock(l.fd)
+		if err != nil {
+			if errors.Is(err, core.EINTR) || errors.Is(err, core.ECONNABORTED) {
 				continue
 			}
-			if err := c.Close(); err != nil {
-				log.Printf("failed to close connection: %v", err)
+			if errors.Is(err, core.EAGAIN) {
+				return
 			}
-
-			// 轮询分配到eventLoop
-			index := i % len(e.eventLoops)
-			// index := fd % len(e.eventLoops)
-			count[index]++
-
-			c2 := newConn(fd, &safeConns, e.localTask,
-				e.options.taskType,
-				e.eventLoops[index],
-				e.options.eventLoopReadBufferSize,
-				e.options.flowBackPressureRemoveRead)
-			safeConns.Add(fd, c2)
-			e.options.callback.OnOpen(c2)
-			err = e.eventLoops[index].AddRead(fd)
-			if err != nil {
-				slog.Error("addRead", "err", err)
+			e.acceptError(l.addr, err)
+			if isFdExhausted(err) && e.shedLoopListener(l) {
+				l.acceptDelay = 0
 				continue
 			}
+			// 不能在event loop里sleep, 暂停监听一段时间
+			l.acceptDelay = nextAcceptDelay(l.acceptDelay)
+			l.pause(l.acceptDelay)
+			return
 		}
-	}()
+		l.acceptDelay = 0
+
+		key, ok := e.admit(raddr)
+		if !ok {
+			e.rejectFd(fd)
+			continue
+		}
+		e.addConn(fd, l.localAddr(fd), raddr, l.eventLoop, l.callback, key)
+	}
+}
+
+// selectEventLoop 使用负载均衡策略给新连接选择event loop
+func (e *MultiEventLoop) selectEventLoop(remoteAddr net.Addr) int {
+	return e.selectEventLoopWithLoads(remoteAddr, make([]int64, len(e.eventLoops)))
+}
 
+func (e *MultiEventLoop) selectEventLoopWithLoads(remoteAddr net.Addr, loads []int64) int {
+	for i, eventLoop := range e.eventLoops {
+		loads[i] = eventLoop.connCount.Load()
+	}
+
+	index := e.options.loadBalancer.Select(remoteAddr, loads) % len(e.eventLoops)
+	if index < 0 {
+		index += len(e.eventLoops)
+	}
+	return index
+}
+
+// addConn 创建连接并加到eventLoop里面, callback是listener单独设置的回调, 可以为nil
+// limitKey是admit返回的key, 连接关闭的时候释放名额
+func (e *MultiEventLoop) addConn(fd int, laddr, raddr net.Addr, eventLoop *eventLoop, callback Callback, limitKey string) {
+	if callback == nil {
+		callback = e.options.callback
+	}
+	c := newConn(fd, &e.safeConns, e.localTask,
+		e.options.taskType,
+		eventLoop,
+		e.options.eventLoopReadBufferSize,
+		e.options.flowBackPressureRemoveRead)
+	c.callback = callback
+	c.options = &e.options
+	c.limitKey = limitKey
+	c.limiter = e.limiter
+	c.localAddr = laddr
+	c.remoteAddr = raddr
+	c.adapter = newAdapter(e.options.getCallback(c))
+	c.startIdleTimer(e.options.idleTimeout)
+	applySockopts(fd, e.options.sockopts)
+	e.safeConns.Add(fd, c)
+	eventLoop.connCount.Add(1)
+	e.options.getCallback(c).OnOpen(c)
+
+	// OnOpen里可能已经关闭了连接, fd可能已经被复用, 不能再注册
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.getFd() == -1 {
+		return
+	}
+	if err := eventLoop.AddRead(fd); err != nil {
+		slog.Error("addRead", "err", err)
+	}
+}
+
+// startEventLoopsLocked 第一次添加listener的时候启动所有event loop, 需要持有e.mu
+func (e *MultiEventLoop) startEventLoopsLocked() {
+	if e.started {
+		return
+	}
+	e.started = true
+	e.wg.Add(len(e.eventLoops))
 	for _, eventLoop := range e.eventLoops {
-		eventLoop := eventLoop
-		go func() {
-			defer wg.Done()
-
-			rbuf := make([]byte, e.options.eventLoopReadBufferSize)
-			for {
-				if _, err := eventLoop.Poll(0, func(fd int, state core.State, err error) {
-
-					c := safeConns.GetUnsafe(fd)
-					// c := safeConns.Get(fd)
-					// slog.Debug("poll", "fd", fd, "state", state, "err", err)
-					if err != nil {
-						if errors.Is(err, core.EAGAIN) {
-							return
-						}
-						if c != nil {
-							c.Close()
-							e.options.callback.OnClose(c, err)
-						}
-						return
-					}
-
-					if c == nil {
-						panic("c is nil")
-					}
-
-					if state.IsWrite() && c.needFlush() {
-						c.flush()
-					}
-
-					// flowBackPressure 主要是为了和删除读事件的背压模式做对比用的
-					// 目前来看，删除读事件的背压模式更高效
-					if e.options.flowBackPressure && c.needFlush() {
-						if e.options.triggerType == core.TriggerTypeLevel {
-							return
-						}
-
-						if e.options.triggerType == core.TriggerTypeEdge {
-							c.readableButNotRead = true
-							return
-						}
-					}
-
-					if c.readableButNotRead {
-						c.readableButNotRead = false
-						e.doRead(c, rbuf)
-						return
-					}
-
-					if state.IsRead() {
-						e.doRead(c, rbuf)
-					}
-
-				}); err != nil {
-					log.Printf("eventLoop.Poll error: %v", err)
+		go e.runEventLoop(eventLoop)
+	}
+}
+
+func (e *MultiEventLoop) runEventLoop(eventLoop *eventLoop) {
+	defer e.wg.Done()
+	defer eventLoop.stop()
+	eventLoop.run()
+	e.lockThread(eventLoop)
+
+	safeConns := &e.safeConns
+	rbuf := make([]byte, e.options.eventLoopReadBufferSize)
+	for !e.closed.Load() {
+		timeout := eventLoop.pollTimeout()
+		eventLoop.setBusy(false)
+		if _, err := eventLoop.Poll(timeout, func(fd int, state core.State, err error) {
+			eventLoop.setBusy(true)
+			if l := eventLoop.listener(fd); l != nil {
+				e.acceptInLoop(l)
+				return
+			}
+
+			// 连接是在accept协程里添加的, 这里需要原子读取
+			c := safeConns.Get(fd)
+			// slog.Debug("poll", "fd", fd, "state", state, "err", err)
+			if err != nil {
+				if errors.Is(err, core.EAGAIN) {
+					return
+				}
+				if c != nil {
+					c.closeWithError(err)
 				}
+				return
+			}
 
+			// 同一批事件里前面的回调或者其他协程已经关闭了这个连接
+			if c == nil {
+				return
 			}
-		}()
+
+			if state.IsWrite() && c.needFlush() {
+				c.flush()
+			}
+
+			// flowBackPressure 主要是为了和删除读事件的背压模式做对比用的
+			// 目前来看，删除读事件的背压模式更高效
+			if e.backPressure() && c.pauseRead() {
+				return
+			}
+
+			if state.IsRead() {
+				e.doRead(c, rbuf, state.IsRDHUP())
+			}
+
+		}); err != nil {
+			log.Printf("eventLoop.Poll error: %v", err)
+		}
+
+		eventLoop.setBusy(true)
+		eventLoop.runTasks()
+		eventLoop.runTimers()
 	}
-	return nil
 }
 
-func (e *MultiEventLoop) doRead(c *Conn, rbuf []byte) {
+// 记录listener, Shutdown的时候关闭. 已经在关闭中返回false
+// 加锁的时候顺便启动event loop, 给wg加上accept协程数，避免和Shutdown里面的wg.Wait竞争
+func (e *MultiEventLoop) trackListener(l io.Closer, goroutines int) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.inShutdown.Load() {
+		if err := l.Close(); err != nil {
+			slog.Error("close listener", "err", err)
+		}
+		return false
+	}
+	e.listeners = append(e.listeners, l)
+	e.startEventLoopsLocked()
+	e.wg.Add(goroutines)
+	return true
+}
+
+// untrackListener listener已经被关闭了, Shutdown的时候不需要再关闭
+func (e *MultiEventLoop) untrackListener(l io.Closer) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.listeners = slices.DeleteFunc(e.listeners, func(x io.Closer) bool {
+		return x == l
+	})
+}
+
+func (e *MultiEventLoop) closeListeners() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, l := range e.listeners {
+		if err := l.Close(); err != nil {
+			slog.Error("close listener", "err", err)
+		}
+	}
+	e.listeners = nil
+}
+
+// Shutdown 优雅关闭
+// 1.关闭listener, 不再接收新连接
+// 2.等待所有连接的写缓冲区发送完成, 直到ctx超时
+// 3.退出所有event loop
+// 4.关闭剩余的连接, OnClose收到ErrServerClosed
+// ctx超时的时候，依然会关闭所有连接，并返回ctx.Err()
+func (e *MultiEventLoop) Shutdown(ctx context.Context) error {
+	e.mu.Lock()
+	first := e.inShutdown.CompareAndSwap(false, true)
+	e.mu.Unlock()
+	if !first {
+		// 已经有其他协程在关闭了
+		select {
+		case <-e.done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	e.closeListeners()
+	err := e.waitFlush(ctx)
+
+	e.closed.Store(true)
+	for _, eventLoop := range e.eventLoops {
+		if err := eventLoop.Wake(); err != nil {
+			slog.Error("wake event loop", "err", err)
+		}
+	}
+	e.wg.Wait()
+
+	e.safeConns.Range(func(fd int, c *Conn) bool {
+		c.closeWithError(ErrServerClosed)
+		return true
+	})
+	close(e.done)
+	return err
+}
+
+// 等待所有连接的写缓冲区清空
+func (e *MultiEventLoop) waitFlush(ctx context.Context) error {
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if !e.hasPendingWrite() {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *MultiEventLoop) hasPendingWrite() bool {
+	pending := false
+	e.safeConns.Range(func(fd int, c *Conn) bool {
+		pending = c.needFlush()
+		return !pending
+	})
+	return pending
+}
+
+// doRead 读取数据, rdhup表示对端已经关闭了写端, 需要一直读到EOF
+func (e *MultiEventLoop) doRead(c *Conn, rbuf []byte, rdhup bool) {
+	// 半关闭之后不再读
+	if c.readEOF {
+		return
+	}
 	for i := 0; ; i++ {
 		if e.options.maxSocketReadTimes > 0 &&
 			i >= e.options.maxSocketReadTimes &&
@@ -223,7 +579,15 @@ func (e *MultiEventLoop) doRead(c *Conn, rbuf []byte) {
 
 		// 循环读取数据
 		c.mu.Lock()
+		// CloseAfterFlush之后或者阻塞适配器暂停读的时候不再读
+		if c.closing || c.readPaused {
+			c.mu.Unlock()
+			return
+		}
 		n, err := core.Read(c.getFd(), rbuf)
+		if n > 0 {
+			c.markRead()
+		}
 		c.mu.Unlock()
 		if err != nil {
 			if errors.Is(err, core.EINTR) {
@@ -236,21 +600,28 @@ func (e *MultiEventLoop) doRead(c *Conn, rbuf []byte) {
 			}
 
 			// 如果不是这个错误直接关闭连接
-			e.options.callback.OnClose(c, err)
-			c.Close()
+			c.closeWithError(err)
 			return
 		}
 
 		if n == 0 {
+			if e.options.onReadEOF != nil && c.adapter == nil {
+				e.handleReadEOF(c)
+				return
+			}
 			// 如果不是这个错误直接关闭连接
-			c.Close()
-			e.options.callback.OnClose(c, io.EOF)
+			c.closeWithError(io.EOF)
 			return
 		}
 		if n > 0 {
 			handleData(c, &e.options, rbuf[:n])
 		}
 
+		// 回调里的写入没有写完, 剩下的数据等写缓冲区清空之后再读
+		if e.backPressure() && c.pauseRead() {
+			return
+		}
+
 		// https://man7.org/linux/man-pages/man7/epoll.7.html
 		// Do I need to continuously read/write a file descriptor until
 		// EAGAIN when using the EPOLLET flag (edge-triggered behavior)?
@@ -276,16 +647,73 @@ func (e *MultiEventLoop) doRead(c *Conn, rbuf []byte) {
 		// The same is true when writing using write(2).  (Avoid this
 		// latter technique if you cannot guarantee that the monitored
 		// file descriptor always refers to a stream-oriented file.)
-		if n < len(rbuf) {
+		if n < len(rbuf) && !rdhup {
 			break
 		}
 	}
 }
 
+// backPressure 是否启用了流量背压
+func (e *MultiEventLoop) backPressure() bool {
+	return e.options.flowBackPressure || e.options.flowBackPressureRemoveRead
+}
+
+// handleReadEOF 对端关闭了写端, 不再关注读事件, 写缓冲区继续发送
+func (e *MultiEventLoop) handleReadEOF(c *Conn) {
+	c.mu.Lock()
+	c.readEOF = true
+	writeShut := c.writeShut
+	if !writeShut {
+		if err := c.eventLoop.SetWriteOnly(c.getFd(), c.pendingNoLock()); err != nil {
+			slog.Error("failed to delete read event", "error", err)
+		}
+	}
+	c.mu.Unlock()
+
+	// 自己的写端也已经关闭了, 两个方向都结束了
+	if writeShut {
+		c.closeWithError(io.EOF)
+		return
+	}
+
+	if e.options.taskType == TaskTypeInEventLoop {
+		e.options.onReadEOF(c)
+		return
+	}
+	// 和OnData走同一个task, 保证在前面的OnData之后执行
+	if err := c.task.AddTask(&c.mu, func() bool {
+		e.options.onReadEOF(c)
+		return true
+	}); err != nil {
+		slog.Error("failed to add task", "error", err)
+	}
+}
+
+// lockThread 开启cpu亲和性的时候, event loop协程独占一个线程并绑定到cpu上
+// 协程退出的时候线程也会退出, 不需要UnlockOSThread
+func (e *MultiEventLoop) lockThread(eventLoop *eventLoop) {
+	if !e.options.cpuAffinity {
+		return
+	}
+
+	runtime.LockOSThread()
+	if err := core.BindCPU(eventLoop.id); err != nil {
+		slog.Error("bind cpu", "id", eventLoop.id, "err", err)
+	}
+}
+
+// Post 把fn放到某个event loop协程里执行(轮询选择)
+// 关闭之后返回ErrServerClosed
+func (e *MultiEventLoop) Post(fn func()) error {
+	index := atomic.AddUint32(&e.nextPost, 1) % uint32(len(e.eventLoops))
+	return e.eventLoops[index].post(fn)
+}
+
 func (e *MultiEventLoop) Free() {
 	for _, eventLoop := range e.eventLoops {
 		eventLoop.Free()
 	}
+	_ = e.reserveFd.Close()
 }
 
 // debug 函数，用于打印连接的缓冲区使用情况
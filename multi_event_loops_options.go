@@ -2,6 +2,8 @@ package pulse
 
 import (
 	"log/slog"
+	"net"
+	"time"
 
 	"github.com/antlabs/pulse/core"
 )
@@ -12,6 +14,7 @@ var (
 	defTaskInitCount           = 8
 	defEventLoopReadBufferSize = 1024 * 4
 	defMaxSocketReadTimes      = 1
+	defShutdownTimeout         = 5 * time.Second
 )
 
 type TaskType int
@@ -25,6 +28,21 @@ const (
 	TaskTypeInConnectionGoroutine
 )
 
+// 写缓冲区超过WithMaxPendingWriteBytes上限时的处理策略
+type PendingWritePolicy int
+
+const (
+	// Write返回ErrWriteBufferFull, 这次Write的数据一个字节都不会发送, 也不放入写缓冲区
+	PendingWriteError PendingWritePolicy = iota
+	// 关闭连接, OnClose收到ErrWriteBufferFull, 这次Write的数据同样不会发送
+	PendingWriteClose
+	// 阻塞调用Write的协程, 直到写缓冲区降到低水位, 超过上限的Write分段放入写缓冲区
+	// 分段等待的时候其他协程的Write排在后面, 每次Write的数据是连续的
+	// 在任何一个event loop协程里调用Write的时候(TaskTypeInEventLoop的回调, Execute, AfterFunc, OnWritable等,
+	// 写的是其他event loop上的连接也一样)等同于PendingWriteError
+	PendingWriteBlock
+)
+
 // 水平触发
 const TriggerTypeLevel = core.TriggerTypeLevel
 
@@ -48,6 +66,41 @@ type Options struct {
 	maxSocketReadTimes         int              // socket单次最大读取次数
 	flowBackPressure           bool             // 流量背压机制，当连接的写缓冲区满了，会暂停读取，直到写缓冲区有空闲空间
 	flowBackPressureRemoveRead bool             // 流量背压机制，当连接的写缓冲区满了，会移除读事件，直到写缓冲区有空闲空间
+	shutdownTimeout            time.Duration    // ctx取消时，优雅关闭等待写缓冲区清空的最长时间
+	reusePort                  bool             // 每个event loop使用自己的SO_REUSEPORT监听socket
+	loadBalancer               LoadBalancer     // 新连接分配event loop的策略
+	eventLoopCount             int              // event loop的个数
+	cpuAffinity                bool             // event loop协程锁定线程并绑定cpu
+
+	onAcceptError func(laddr net.Addr, err error) // accept出错时的回调
+
+	maxConnections      int                            // 最大连接数
+	maxConnectionsPerIP int                            // 单个ip的最大连接数
+	onAccept            func(remoteAddr net.Addr) bool // 准入回调, 返回false拒绝连接
+	rejectResponse      []byte                         // 拒绝连接之前写给客户端的数据
+
+	idleTimeout time.Duration // 连接空闲超时, 一直没有读写的时候关闭连接
+
+	sockopts []sockopt // 新连接默认的socket选项
+
+	onReadEOF func(c *Conn) // 对端关闭写端(半关闭)时的回调, 设置了之后读到EOF不关闭连接
+
+	highWatermark int           // 写缓冲区高水位, 超过之后回调onBufferFull
+	lowWatermark  int           // 写缓冲区低水位, 超过高水位之后降到这里回调onWritable
+	onBufferFull  func(c *Conn) // 写缓冲区超过高水位
+	onWritable    func(c *Conn) // 写缓冲区从高水位降到低水位
+
+	maxPendingWriteBytes int                // 单个连接写缓冲区的上限
+	pendingWritePolicy   PendingWritePolicy // 超过上限时的处理策略
+}
+
+// pendingResume PendingWriteBlock策略下, 写缓冲区降到这里唤醒等待的Write
+// 设置了水位就用低水位, 否则是上限的一半
+func (o *Options) pendingResume() int {
+	if o.highWatermark > 0 && o.lowWatermark < o.maxPendingWriteBytes {
+		return o.lowWatermark
+	}
+	return o.maxPendingWriteBytes / 2
 }
 
 // 单次可读事情，最大读取次数(水平触发模式有效)
@@ -93,10 +146,8 @@ func WithEventLoopReadBufferSize(size int) func(*Options) {
 }
 
 // 设置流量背压机制，当连接的写缓冲区满了，会暂停读取，直到写缓冲区有空闲空间
-// 目前垂直(et)触发模式下会有问题
-// 如果要在et模式实现流量背压机制，就需要自己管理fd的可读/可写状态, 因为内核只会在 不可读->可读 的时候触发事件, 可读但是未读取的时候不会触发事件
-// 可写同理, 可写->不可写 的时候触发事件, 不可写但是未写入的时候不会触发事件
-// TODO et模式支持下
+// et模式下内核只会在 不可读->可读 的时候触发事件, 可读但是未读取的时候不会触发事件
+// 所以暂停读取的连接会记下来, 写缓冲区清空之后重新注册读事件, 让内核再通知一次
 func WithFlowBackPressure(enable bool) func(*Options) {
 	return func(o *Options) {
 		o.flowBackPressure = enable
@@ -104,10 +155,225 @@ func WithFlowBackPressure(enable bool) func(*Options) {
 }
 
 // 设置流量背压机制，当连接的写缓冲区满了，会移除读事件，直到写缓冲区有空闲空间
-// 第二种背压机制会比第一种背压机制更高效(lt模式下，et没有实现), 7945hx cpu上，第二种是3.4GB/s的读写 第一种是3.0GB/s的读写
-// TODO et模式优化下
+// 第二种背压机制会比第一种背压机制更高效(lt模式下), 7945hx cpu上，第二种是3.4GB/s的读写 第一种是3.0GB/s的读写
+// lt和et模式都支持, 写缓冲区清空之后重新添加读事件
 func WithFlowBackPressureRemoveRead(enable bool) func(*Options) {
 	return func(o *Options) {
 		o.flowBackPressureRemoveRead = enable
 	}
 }
+
+// 设置ctx取消时优雅关闭的超时时间, 超时之后未发送完的数据会被丢弃
+func WithShutdownTimeout(timeout time.Duration) func(*Options) {
+	return func(o *Options) {
+		o.shutdownTimeout = timeout
+	}
+}
+
+// 每个event loop创建自己的SO_REUSEPORT监听socket, 在event loop里面accept
+// 由内核做连接的负载均衡, 没有单独的accept协程, 也不需要每个连接dup/close一次
+// windows不支持
+func WithReusePort(enable bool) func(*Options) {
+	return func(o *Options) {
+		o.reusePort = enable
+	}
+}
+
+// 设置新连接分配event loop的策略, 默认是RoundRobin()
+// 内置RoundRobin(), LeastConnections(), SourceIPHash(), 也可以用LoadBalancerFunc自定义
+// SO_REUSEPORT模式由内核分配, 不使用这个策略
+func WithLoadBalancer(lb LoadBalancer) func(*Options) {
+	return func(o *Options) {
+		o.loadBalancer = lb
+	}
+}
+
+// 设置event loop的个数, 默认是runtime.NumCPU()
+// 容器里有cpu quota限制或者sidecar场景, 可以设置得小一些
+func WithEventLoopCount(n int) func(*Options) {
+	return func(o *Options) {
+		o.eventLoopCount = n
+	}
+}
+
+// 每个event loop协程锁定一个线程(runtime.LockOSThread), linux下还会用sched_setaffinity绑定到cpu上
+// 第i个event loop绑定到进程允许使用的第i个cpu, 延迟更稳定
+func WithCPUAffinity(enable bool) func(*Options) {
+	return func(o *Options) {
+		o.cpuAffinity = enable
+	}
+}
+
+// 设置accept出错时的回调, 比如fd耗尽(EMFILE/ENFILE), 可以用来上报监控
+// SO_REUSEPORT模式下在event loop协程里调用, 不要阻塞
+func WithOnAcceptError(fn func(laddr net.Addr, err error)) func(*Options) {
+	return func(o *Options) {
+		o.onAcceptError = fn
+	}
+}
+
+// 设置最大连接数, 超过之后新连接会被直接关闭, <=0不限制
+func WithMaxConnections(n int) func(*Options) {
+	return func(o *Options) {
+		o.maxConnections = n
+	}
+}
+
+// 设置单个ip的最大连接数, 防止一个客户端占满fd, <=0不限制
+// unix socket等没有ip的连接不受限制
+func WithMaxConnectionsPerIP(n int) func(*Options) {
+	return func(o *Options) {
+		o.maxConnectionsPerIP = n
+	}
+}
+
+// 设置准入回调, 在OnOpen之前调用, 返回false的时候直接关闭连接, 不会创建Conn
+// 在accept协程或者event loop协程里调用, 不要阻塞
+func WithOnAccept(fn func(remoteAddr net.Addr) bool) func(*Options) {
+	return func(o *Options) {
+		o.onAccept = fn
+	}
+}
+
+// 设置拒绝连接的时候写给客户端的数据, 比如"HTTP/1.1 503 Service Unavailable\r\n\r\n"
+// 只尝试写一次, 写不进去就直接关闭
+func WithRejectResponse(resp []byte) func(*Options) {
+	return func(o *Options) {
+		o.rejectResponse = resp
+	}
+}
+
+// 设置连接的空闲超时, 超过d没有读写数据的连接会被关闭, OnClose收到ErrIdleTimeout
+// 由event loop的时间轮检查, 精度是10ms, <=0不检查
+func WithIdleTimeout(d time.Duration) func(*Options) {
+	return func(o *Options) {
+		o.idleTimeout = d
+	}
+}
+
+func withSockopt(name string, apply func(fd int) error) func(*Options) {
+	return func(o *Options) {
+		o.sockopts = append(o.sockopts, sockopt{name: name, apply: apply})
+	}
+}
+
+// 新连接默认的内核接收缓冲区大小(SO_RCVBUF)
+func WithSocketRecvBuffer(bytes int) func(*Options) {
+	return withSockopt("SO_RCVBUF", func(fd int) error {
+		return core.SetRecvBufferSize(fd, bytes)
+	})
+}
+
+// 新连接默认的内核发送缓冲区大小(SO_SNDBUF)
+func WithSocketSendBuffer(bytes int) func(*Options) {
+	return withSockopt("SO_SNDBUF", func(fd int) error {
+		return core.SetSendBufferSize(fd, bytes)
+	})
+}
+
+// 新连接默认的SO_LINGER, 含义见Conn.SetLinger
+func WithLinger(sec int) func(*Options) {
+	return withSockopt("SO_LINGER", func(fd int) error {
+		return core.SetLinger(fd, sec)
+	})
+}
+
+// 新连接默认的TCP_USER_TIMEOUT, 只支持linux
+func WithTCPUserTimeout(d time.Duration) func(*Options) {
+	return withSockopt("TCP_USER_TIMEOUT", func(fd int) error {
+		return core.SetTCPUserTimeout(fd, d)
+	})
+}
+
+// 新连接默认打开TCP_QUICKACK, 只支持linux
+func WithQuickAck(enable bool) func(*Options) {
+	return withSockopt("TCP_QUICKACK", func(fd int) error {
+		return core.SetQuickAck(fd, enable)
+	})
+}
+
+// 新连接默认打开TCP_CORK(bsd是TCP_NOPUSH)
+func WithCork(enable bool) func(*Options) {
+	return withSockopt("TCP_CORK", func(fd int) error {
+		return core.SetCork(fd, enable)
+	})
+}
+
+// 新连接默认的IP_TOS(ipv6是IPV6_TCLASS)
+func WithTOS(tos int) func(*Options) {
+	return withSockopt("IP_TOS", func(fd int) error {
+		return core.SetTOS(fd, tos)
+	})
+}
+
+// 设置对端半关闭(shutdown SHUT_WR)时的回调, 设置之后读到EOF不会关闭连接, 写缓冲区会继续发送
+// 和OnData在同一个协程里按顺序调用, 回调之后不会再有OnData. 回复完之后调用c.CloseWrite()或者c.Close()
+// 阻塞读写适配器(NetConnCallback/NetListener)的连接不支持, 读到EOF直接关闭
+func WithOnReadEOF(fn func(c *Conn)) func(*Options) {
+	return func(o *Options) {
+		o.onReadEOF = fn
+	}
+}
+
+// 设置写缓冲区的高低水位(字节), 写缓冲区是对端来不及接收, 还没有写入内核的数据
+// 超过high的时候回调OnBufferFull, 之后降到low以下回调OnWritable, 生产者可以据此暂停和恢复
+// low > high的时候low = high, low < 0的时候low = 0, high <= 0不检查水位
+func WithWriteBufferWatermarks(high, low int) func(*Options) {
+	return func(o *Options) {
+		o.highWatermark = high
+		o.lowWatermark = max(min(low, high), 0)
+	}
+}
+
+// 设置写缓冲区超过高水位时的回调, 在调用Write的协程里执行, 不要阻塞
+func WithOnBufferFull(fn func(c *Conn)) func(*Options) {
+	return func(o *Options) {
+		o.onBufferFull = fn
+	}
+}
+
+// 设置写缓冲区降到低水位时的回调, 一般在event loop里执行, 不要阻塞
+func WithOnWritable(fn func(c *Conn)) func(*Options) {
+	return func(o *Options) {
+		o.onWritable = fn
+	}
+}
+
+// 设置单个连接写缓冲区的上限(字节), 防止读得慢的对端让服务端缓存大量数据
+// 超过上限时按policy处理, 见PendingWritePolicy. max <= 0不限制(默认)
+// 写缓冲区为空的时候Write先直接写内核, 写不下的部分放入写缓冲区
+// 是否超过上限在写之前按整个Write的长度判断, 所以单次Write超过max的时候也会按policy处理
+func WithMaxPendingWriteBytes(max int, policy PendingWritePolicy) func(*Options) {
+	return func(o *Options) {
+		o.maxPendingWriteBytes = max
+		o.pendingWritePolicy = policy
+	}
+}
+
+// 单个listener的配置
+type ListenerOptions struct {
+	callback Callback // 不设置的时候使用WithCallback的回调
+}
+
+func newListenerOptions(opts []func(*ListenerOptions)) *ListenerOptions {
+	var lo ListenerOptions
+	for _, opt := range opts {
+		opt(&lo)
+	}
+	return &lo
+}
+
+// 给listener单独设置回调函数, 比如同一组event loop上的管理端口
+func WithListenerCallback(callback Callback) func(*ListenerOptions) {
+	return func(o *ListenerOptions) {
+		o.callback = callback
+	}
+}
+
+// 连接使用的回调函数, listener单独设置了回调的时候优先使用
+func (o *Options) getCallback(c *Conn) Callback {
+	if c.callback != nil {
+		return c.callback
+	}
+	return o.callback
+}
@@ -0,0 +1,497 @@
+package pulse
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/antlabs/pulse/core"
+)
+
+// getFreeAddr 获取一个空闲的本地地址
+func getFreeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	addr := l.Addr().String()
+	if err := l.Close(); err != nil {
+		t.Fatalf("failed to close listener: %v", err)
+	}
+	return addr
+}
+
+// dialRetry 等待服务端启动之后再连接
+func dialRetry(t *testing.T, network, addr string) net.Conn {
+	var lastErr error
+	for i := 0; i < 100; i++ {
+		c, err := net.Dial(network, addr)
+		if err == nil {
+			return c
+		}
+		lastErr = err
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("failed to dial %s: %v", addr, lastErr)
+	return nil
+}
+
+type shutdownCallback struct {
+	mu       sync.Mutex
+	opened   chan *Conn
+	closeErr []error
+}
+
+func (s *shutdownCallback) OnOpen(c *Conn) {
+	s.opened <- c
+}
+
+func (s *shutdownCallback) OnData(c *Conn, data []byte) {
+	if _, err := c.Write(data); err != nil {
+		return
+	}
+}
+
+func (s *shutdownCallback) OnClose(c *Conn, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeErr = append(s.closeErr, err)
+}
+
+func (s *shutdownCallback) closeErrors() []error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]error(nil), s.closeErr...)
+}
+
+func TestMultiEventLoop_Shutdown(t *testing.T) {
+	cb := &shutdownCallback{opened: make(chan *Conn, 1)}
+	el, err := NewMultiEventLoop(context.Background(), WithCallback(cb), WithTaskType(TaskTypeInEventLoop))
+	if err != nil {
+		t.Fatalf("NewMultiEventLoop() error = %v", err)
+	}
+	defer el.Free()
+
+	addr := getFreeAddr(t)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- el.ListenAndServe(addr)
+	}()
+
+	client := dialRetry(t, "tcp", addr)
+	defer client.Close()
+
+	select {
+	case <-cb.opened:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for OnOpen")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := el.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, ErrServerClosed) {
+			t.Errorf("ListenAndServe() error = %v, want %v", err, ErrServerClosed)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("ListenAndServe did not return after Shutdown")
+	}
+
+	errs := cb.closeErrors()
+	if len(errs) != 1 || !errors.Is(errs[0], ErrServerClosed) {
+		t.Errorf("OnClose errors = %v, want [%v]", errs, ErrServerClosed)
+	}
+
+	// 客户端应该读到EOF
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	var buf [1]byte
+	if _, err := client.Read(buf[:]); !errors.Is(err, io.EOF) {
+		t.Errorf("client Read() error = %v, want %v", err, io.EOF)
+	}
+
+	// 关闭之后不能再启动
+	if err := el.ListenAndServe(addr); !errors.Is(err, ErrServerClosed) {
+		t.Errorf("ListenAndServe() after Shutdown error = %v, want %v", err, ErrServerClosed)
+	}
+}
+
+func TestMultiEventLoop_ContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cb := &shutdownCallback{opened: make(chan *Conn, 1)}
+	el, err := NewMultiEventLoop(ctx, WithCallback(cb), WithTaskType(TaskTypeInEventLoop))
+	if err != nil {
+		t.Fatalf("NewMultiEventLoop() error = %v", err)
+	}
+	defer el.Free()
+
+	addr := getFreeAddr(t)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- el.ListenAndServe(addr)
+	}()
+
+	client := dialRetry(t, "tcp", addr)
+	defer client.Close()
+	<-cb.opened
+
+	cancel()
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, ErrServerClosed) {
+			t.Errorf("ListenAndServe() error = %v, want %v", err, ErrServerClosed)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("ListenAndServe did not return after ctx cancel")
+	}
+
+	if _, err := net.DialTimeout("tcp", addr, 100*time.Millisecond); err == nil {
+		t.Error("listener should be closed after ctx cancel")
+	}
+}
+
+type echoCallback struct{}
+
+func (echoCallback) OnOpen(c *Conn) {}
+
+func (echoCallback) OnData(c *Conn, data []byte) {
+	if _, err := c.Write(data); err != nil {
+		return
+	}
+}
+
+func (echoCallback) OnClose(c *Conn, err error) {}
+
+// echoRoundTrip 写入数据并读取回显
+func echoRoundTrip(t *testing.T, c net.Conn, msg string) {
+	t.Helper()
+	if _, err := c.Write([]byte(msg)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	buf := make([]byte, len(msg))
+	_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if string(buf) != msg {
+		t.Errorf("Read() = %q, want %q", buf, msg)
+	}
+}
+
+func TestMultiEventLoop_ReusePort(t *testing.T) {
+	for _, triggerType := range []core.TriggerType{TriggerTypeLevel, TriggerTypeEdge} {
+		el, err := NewMultiEventLoop(context.Background(),
+			WithCallback(echoCallback{}),
+			WithTaskType(TaskTypeInEventLoop),
+			WithTriggerType(triggerType),
+			WithReusePort(true))
+		if err != nil {
+			t.Fatalf("NewMultiEventLoop() error = %v", err)
+		}
+
+		addr := getFreeAddr(t)
+		serveErr := make(chan error, 1)
+		go func() {
+			serveErr <- el.ListenAndServe(addr)
+		}()
+
+		clients := make([]net.Conn, 0, 16)
+		for i := 0; i < 16; i++ {
+			c := dialRetry(t, "tcp", addr)
+			clients = append(clients, c)
+			echoRoundTrip(t, c, "hello reuseport")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		if err := el.Shutdown(ctx); err != nil {
+			t.Fatalf("Shutdown() error = %v", err)
+		}
+		cancel()
+		if err := <-serveErr; !errors.Is(err, ErrServerClosed) {
+			t.Errorf("ListenAndServe() error = %v, want %v", err, ErrServerClosed)
+		}
+		for _, c := range clients {
+			c.Close()
+		}
+
+		// 所有监听socket都应该被关闭了
+		if c, err := net.DialTimeout("tcp", addr, 100*time.Millisecond); err == nil {
+			c.Close()
+			t.Error("reuseport listeners should be closed after Shutdown")
+		}
+		el.Free()
+	}
+}
+
+func TestMultiEventLoop_EventLoopCount(t *testing.T) {
+	el, err := NewMultiEventLoop(context.Background(),
+		WithCallback(echoCallback{}),
+		WithTaskType(TaskTypeInEventLoop),
+		WithEventLoopCount(3),
+		WithCPUAffinity(true))
+	if err != nil {
+		t.Fatalf("NewMultiEventLoop() error = %v", err)
+	}
+	defer el.Free()
+
+	if len(el.eventLoops) != 3 {
+		t.Fatalf("event loop count = %d, want 3", len(el.eventLoops))
+	}
+
+	addr := getFreeAddr(t)
+	go func() {
+		_ = el.ListenAndServe(addr)
+	}()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = el.Shutdown(ctx)
+	}()
+
+	for i := 0; i < 3; i++ {
+		c := dialRetry(t, "tcp", addr)
+		defer c.Close()
+		echoRoundTrip(t, c, "hello affinity")
+	}
+}
+
+// prefixCallback 回显的时候加上前缀, 用来区分是哪个listener的回调
+type prefixCallback struct {
+	prefix string
+}
+
+func (p prefixCallback) OnOpen(c *Conn) {}
+
+func (p prefixCallback) OnData(c *Conn, data []byte) {
+	if _, err := c.Write(append([]byte(p.prefix), data...)); err != nil {
+		return
+	}
+}
+
+func (p prefixCallback) OnClose(c *Conn, err error) {}
+
+func TestMultiEventLoop_ListenMultiple(t *testing.T) {
+	for _, reusePort := range []bool{false, true} {
+		el, err := NewMultiEventLoop(context.Background(),
+			WithCallback(echoCallback{}),
+			WithTaskType(TaskTypeInEventLoop),
+			WithEventLoopCount(2),
+			WithReusePort(reusePort))
+		if err != nil {
+			t.Fatalf("NewMultiEventLoop() error = %v", err)
+		}
+
+		tcpAddr, err := el.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Listen(tcp) error = %v", err)
+		}
+		tcp4Addr, err := el.Listen("tcp4", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Listen(tcp4) error = %v", err)
+		}
+
+		sock := filepath.Join(t.TempDir(), "admin.sock")
+		unixAddr, err := el.Listen("unix", sock, WithListenerCallback(prefixCallback{prefix: "admin:"}))
+		if err != nil {
+			t.Fatalf("Listen(unix) error = %v", err)
+		}
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("net.Listen() error = %v", err)
+		}
+		serveErr := make(chan error, 1)
+		go func() {
+			serveErr <- el.Serve(l, WithListenerCallback(prefixCallback{prefix: "serve:"}))
+		}()
+
+		c1 := dialRetry(t, "tcp", tcpAddr.String())
+		echoRoundTrip(t, c1, "hello")
+		c2 := dialRetry(t, "tcp4", tcp4Addr.String())
+		echoRoundTrip(t, c2, "hello")
+
+		c3 := dialRetry(t, "unix", unixAddr.String())
+		if _, err := c3.Write([]byte("ping")); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		buf := make([]byte, len("admin:ping"))
+		_ = c3.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err := io.ReadFull(c3, buf); err != nil || string(buf) != "admin:ping" {
+			t.Errorf("unix Read() = %q, %v, want admin:ping", buf, err)
+		}
+
+		c4 := dialRetry(t, "tcp", l.Addr().String())
+		if _, err := c4.Write([]byte("ping")); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		buf = make([]byte, len("serve:ping"))
+		_ = c4.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err := io.ReadFull(c4, buf); err != nil || string(buf) != "serve:ping" {
+			t.Errorf("Serve Read() = %q, %v, want serve:ping", buf, err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		if err := el.Shutdown(ctx); err != nil {
+			t.Fatalf("Shutdown() error = %v", err)
+		}
+		cancel()
+		if err := <-serveErr; !errors.Is(err, ErrServerClosed) {
+			t.Errorf("Serve() error = %v, want %v", err, ErrServerClosed)
+		}
+		for _, c := range []net.Conn{c1, c2, c3, c4} {
+			c.Close()
+		}
+		el.Free()
+	}
+}
+
+// flow_backpressure示例的场景: 回显的数据比请求大很多, 客户端先发送, 过一会才读
+func TestMultiEventLoop_FlowBackPressure(t *testing.T) {
+	const (
+		scale    = 100 // 回显的数据是请求的100倍
+		reqSize  = 100
+		requests = 2000
+		rbufSize = 4096
+	)
+	for _, triggerType := range []core.TriggerType{TriggerTypeLevel, TriggerTypeEdge} {
+		for _, mode := range []struct {
+			name string
+			opt  func(*Options)
+		}{
+			{name: "pause read", opt: WithFlowBackPressure(true)},
+			{name: "remove read", opt: WithFlowBackPressureRemoveRead(true)},
+		} {
+			name := "level/" + mode.name
+			if triggerType == TriggerTypeEdge {
+				name = "edge/" + mode.name
+			}
+			t.Run(name, func(t *testing.T) {
+				var maxBuffered atomic.Int64
+				el, err := NewMultiEventLoop(context.Background(),
+					WithCallback(ToCallback(func(c *Conn, err error) {
+					}, func(c *Conn, data []byte) {
+						if _, err := c.Write(bytes.Repeat(data, scale)); err != nil {
+							return
+						}
+						if n := int64(c.Buffered()); n > maxBuffered.Load() {
+							maxBuffered.Store(n)
+						}
+					}, func(c *Conn, err error) {})),
+					WithTaskType(TaskTypeInEventLoop),
+					WithTriggerType(triggerType),
+					WithEventLoopReadBufferSize(rbufSize),
+					WithSocketSendBuffer(64*1024),
+					mode.opt)
+				if err != nil {
+					t.Fatalf("NewMultiEventLoop() error = %v", err)
+				}
+				defer el.Free()
+				addr, err := el.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatalf("Listen() error = %v", err)
+				}
+				defer func() {
+					ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+					defer cancel()
+					_ = el.Shutdown(ctx)
+				}()
+
+				c := dialRetry(t, "tcp", addr.String())
+				defer c.Close()
+				writeErr := make(chan error, 1)
+				go func() {
+					req := bytes.Repeat([]byte("x"), reqSize)
+					for i := 0; i < requests; i++ {
+						if _, err := c.Write(req); err != nil {
+							writeErr <- err
+							return
+						}
+					}
+					writeErr <- nil
+				}()
+
+				// 先不读, 服务端的写缓冲区满了之后应该暂停读
+				time.Sleep(50 * time.Millisecond)
+				_ = c.SetReadDeadline(time.Now().Add(10 * time.Second))
+				got, err := io.ReadAll(io.LimitReader(c, reqSize*requests*scale))
+				if err != nil {
+					t.Fatalf("ReadAll() error = %v", err)
+				}
+				if len(got) != reqSize*requests*scale || bytes.Count(got, []byte("x")) != len(got) {
+					t.Fatalf("ReadAll() = %d bytes, want %d bytes of x", len(got), reqSize*requests*scale)
+				}
+				if err := <-writeErr; err != nil {
+					t.Errorf("client Write() error = %v", err)
+				}
+				// 写缓冲区有数据的时候不再读, 写缓冲区最多是一次读的数据的回显
+				if n := maxBuffered.Load(); n > rbufSize*scale {
+					t.Errorf("max Buffered() = %d, want <= %d", n, rbufSize*scale)
+				}
+			})
+		}
+	}
+}
+
+// Serve的listener被其他地方关闭的时候, accept协程退出, Serve返回
+func TestMultiEventLoop_ServeListenerClosed(t *testing.T) {
+	var acceptErrs atomic.Int32
+	el, err := NewMultiEventLoop(context.Background(),
+		WithCallback(echoCallback{}),
+		WithTaskType(TaskTypeInEventLoop),
+		WithOnAcceptError(func(laddr net.Addr, err error) {
+			acceptErrs.Add(1)
+		}))
+	if err != nil {
+		t.Fatalf("NewMultiEventLoop() error = %v", err)
+	}
+	defer el.Free()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() error = %v", err)
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- el.Serve(l)
+	}()
+	c := dialRetry(t, "tcp", l.Addr().String())
+	echoRoundTrip(t, c, "hello")
+	c.Close()
+
+	l.Close()
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("Serve() error = %v, want %v", err, net.ErrClosed)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve did not return after the listener was closed")
+	}
+	if n := acceptErrs.Load(); n != 0 {
+		t.Errorf("OnAcceptError called %d times, want 0", n)
+	}
+
+	el.mu.Lock()
+	n := len(el.listeners)
+	el.mu.Unlock()
+	if n != 0 {
+		t.Errorf("tracked listeners = %d, want 0", n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := el.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown() error = %v", err)
+	}
+}
@@ -0,0 +1,343 @@
+package pulse
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+var _ net.Conn = (*Conn)(nil)
+
+// 没有使用NetConnCallback或者NetListener的连接, 数据只会通过OnData回调
+var errReadNotSupported = errors.New("pulse: Read requires NetConnCallback or NetListener")
+
+// netConnAdapter 阻塞读写适配器, 让*Conn可以交给crypto/tls, bufio, http.Serve等阻塞式的代码
+// 1.event loop通过OnData把数据写入读缓冲区, 唤醒阻塞在Read里的协程
+// 2.Write等待数据全部写入内核之后才返回, 和net.Conn一样, Write返回之后Close不会丢数据
+type netConnAdapter struct {
+	mu            sync.Mutex
+	rbuf          []byte
+	off           int
+	err           error         // 连接关闭的原因, 读完缓冲区之后Read返回这个错误
+	readable      chan struct{} // 有新数据, 连接关闭或者读超时时间被修改
+	writable      chan struct{} // 写缓冲区清空, 连接关闭或者写超时时间被修改
+	readDeadline  time.Time
+	writeDeadline time.Time
+	paused        bool // 读缓冲区超过netConnMaxReadBuffer, 暂停了读
+}
+
+// 阻塞适配器读缓冲区的上限, Read跟不上的时候暂停读, 读完之后恢复
+const netConnMaxReadBuffer = 4 << 20
+
+func newNetConnAdapter() *netConnAdapter {
+	return &netConnAdapter{
+		readable: make(chan struct{}, 1),
+		writable: make(chan struct{}, 1),
+	}
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// feed 写入OnData读到的数据, data在回调返回之后会被复用, 需要复制
+// 缓冲区超过上限的时候暂停读, 数据留在内核里, 由TCP的流量控制让对端慢下来
+func (a *netConnAdapter) feed(c *Conn, data []byte) {
+	a.mu.Lock()
+	if a.off > 0 {
+		n := copy(a.rbuf, a.rbuf[a.off:])
+		a.rbuf = a.rbuf[:n]
+		a.off = 0
+	}
+	a.rbuf = append(a.rbuf, data...)
+	pause := !a.paused && len(a.rbuf) >= netConnMaxReadBuffer
+	if pause {
+		a.paused = true
+	}
+	a.mu.Unlock()
+	notify(a.readable)
+	if pause {
+		c.stopRead()
+	}
+}
+
+func (a *netConnAdapter) isPaused() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.paused
+}
+
+// closeWithError 记录连接关闭的原因, 只有第一次有效
+func (a *netConnAdapter) closeWithError(err error) {
+	if err == nil {
+		err = io.EOF
+	}
+	a.mu.Lock()
+	if a.err == nil {
+		a.err = err
+	}
+	a.mu.Unlock()
+	notify(a.readable)
+	notify(a.writable)
+}
+
+func (a *netConnAdapter) read(c *Conn, p []byte) (int, error) {
+	for {
+		a.mu.Lock()
+		if a.off < len(a.rbuf) {
+			n := copy(p, a.rbuf[a.off:])
+			a.off += n
+			resume := false
+			if a.off == len(a.rbuf) {
+				a.rbuf = a.rbuf[:0]
+				a.off = 0
+				resume, a.paused = a.paused, false
+			}
+			a.mu.Unlock()
+			if resume {
+				c.resumeRead()
+			}
+			return n, nil
+		}
+		err, deadline := a.err, a.readDeadline
+		a.mu.Unlock()
+
+		if err != nil {
+			return 0, err
+		}
+		if err := wait(a.readable, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// waitFlushed 等待写缓冲区清空
+func (a *netConnAdapter) waitFlushed(c *Conn) error {
+	for {
+		if !c.needFlush() {
+			return nil
+		}
+
+		a.mu.Lock()
+		err, deadline := a.err, a.writeDeadline
+		a.mu.Unlock()
+		if err != nil {
+			return net.ErrClosed
+		}
+		if err := wait(a.writable, deadline); err != nil {
+			return err
+		}
+	}
+}
+
+// writeTimeout 写超时时间已经过了
+func (a *netConnAdapter) writeTimeout() bool {
+	a.mu.Lock()
+	deadline := a.writeDeadline
+	a.mu.Unlock()
+	return !deadline.IsZero() && !time.Now().Before(deadline)
+}
+
+func (a *netConnAdapter) setReadDeadline(t time.Time) {
+	a.mu.Lock()
+	a.readDeadline = t
+	a.mu.Unlock()
+	notify(a.readable)
+}
+
+func (a *netConnAdapter) setWriteDeadline(t time.Time) {
+	a.mu.Lock()
+	a.writeDeadline = t
+	a.mu.Unlock()
+	notify(a.writable)
+}
+
+// wait 等待通知或者超时, 被唤醒之后调用者需要重新检查状态
+func wait(ch chan struct{}, deadline time.Time) error {
+	if deadline.IsZero() {
+		<-ch
+		return nil
+	}
+
+	d := time.Until(deadline)
+	if d <= 0 {
+		return os.ErrDeadlineExceeded
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ch:
+		return nil
+	case <-timer.C:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+// Read 实现net.Conn, 只能在NetConnCallback或者NetListener的连接上使用
+// 连接关闭之后先读完缓冲区里的数据, 然后返回关闭的原因, 对端关闭的时候是io.EOF
+func (c *Conn) Read(p []byte) (int, error) {
+	if c.adapter == nil {
+		return 0, errReadNotSupported
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return c.adapter.read(c, p)
+}
+
+// stopRead 阻塞适配器的读缓冲区满了, 去掉读事件, 写缓冲区里还有数据的时候保留写事件
+func (c *Conn) stopRead() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fd := c.getFd()
+	// Read已经读完了缓冲区, 不用再暂停
+	if fd == -1 || c.readPaused || !c.adapter.isPaused() {
+		return
+	}
+	c.readPaused = true
+	if c.readEOF {
+		return
+	}
+	if err := c.eventLoop.SetWriteOnly(fd, c.pendingNoLock()); err != nil {
+		slog.Error("failed to pause read", "error", err)
+	}
+}
+
+// resumeRead Read读完了缓冲区, 在event loop协程里重新注册读事件
+// 暂停期间内核里的数据重新注册之后会再通知一次
+func (c *Conn) resumeRead() {
+	err := c.Execute(func() {
+		c.mu.Lock()
+		defer c.unlock()
+		if c.getFd() == -1 || !c.readPaused {
+			return
+		}
+		c.readPaused = false
+		if c.readEOF || c.closing {
+			return
+		}
+		if c.pendingNoLock() {
+			if err := c.waitWritable(); err != nil {
+				c.closeNoLock(err)
+			}
+			return
+		}
+		c.rearmRead = false
+		if err := c.eventLoop.ResetRead(c.getFd()); err != nil {
+			slog.Error("failed to resume read", "error", err)
+		}
+	})
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		slog.Error("failed to resume read", "error", err)
+	}
+}
+
+// netConnCallbacker NetConnCallback和NetListener实现这个接口, 创建连接的时候打开阻塞读写适配器
+type netConnCallbacker interface {
+	netConn()
+}
+
+// newAdapter 在连接对其他协程可见之前调用
+func newAdapter(callback Callback) *netConnAdapter {
+	if _, ok := callback.(netConnCallbacker); ok {
+		return newNetConnAdapter()
+	}
+	return nil
+}
+
+type netConnCallback struct {
+	handler func(c *Conn)
+}
+
+// NetConnCallback 把连接当成net.Conn使用, 每个连接在单独的协程里调用handler
+// handler里可以直接使用Read/Write, 比如tls.Server(c, config), 由handler负责关闭连接
+func NetConnCallback(handler func(c *Conn)) Callback {
+	return &netConnCallback{handler: handler}
+}
+
+func (n *netConnCallback) netConn() {}
+
+func (n *netConnCallback) OnOpen(c *Conn) {
+	go n.handler(c)
+}
+
+func (n *netConnCallback) OnData(c *Conn, data []byte) {
+	c.adapter.feed(c, data)
+}
+
+// OnClose 关闭的原因已经在关闭连接的时候交给适配器了
+func (n *netConnCallback) OnClose(c *Conn, err error) {}
+
+// NetListener 把event loop上的新连接转成net.Listener, 可以直接交给http.Serve等
+// 同时实现了Callback, 通过WithCallback或者WithListenerCallback设置
+type NetListener struct {
+	addr      net.Addr
+	conns     chan *Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// 等待Accept的连接数, 超过之后新连接会被直接关闭
+const netListenerBacklog = 1024
+
+// NewNetListener addr是Addr()返回的地址, 一般是Listen的返回值
+func NewNetListener(addr net.Addr) *NetListener {
+	return &NetListener{
+		addr:  addr,
+		conns: make(chan *Conn, netListenerBacklog),
+		done:  make(chan struct{}),
+	}
+}
+
+func (l *NetListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close 只是不再Accept新连接, 不会关闭event loop和已经Accept的连接
+func (l *NetListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+	return nil
+}
+
+func (l *NetListener) Addr() net.Addr {
+	return l.addr
+}
+
+func (l *NetListener) netConn() {}
+
+// OnOpen 在event loop里调用, 不能阻塞
+func (l *NetListener) OnOpen(c *Conn) {
+	select {
+	case <-l.done:
+		c.Close()
+		return
+	default:
+	}
+
+	select {
+	case l.conns <- c:
+	default:
+		slog.Error("net listener backlog full, close conn", "remote", c.RemoteAddr())
+		c.Close()
+	}
+}
+
+func (l *NetListener) OnData(c *Conn, data []byte) {
+	c.adapter.feed(c, data)
+}
+
+func (l *NetListener) OnClose(c *Conn, err error) {}
@@ -0,0 +1,293 @@
+package pulse
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/antlabs/pulse/core"
+)
+
+// newNetConnServer 启动一个使用callback的服务, 返回监听地址
+func newNetConnServer(t *testing.T, callback Callback, taskType TaskType) net.Addr {
+	t.Helper()
+	el, err := NewMultiEventLoop(context.Background(), WithCallback(callback), WithTaskType(taskType))
+	if err != nil {
+		t.Fatalf("NewMultiEventLoop() error = %v", err)
+	}
+	addr, err := el.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = el.Shutdown(ctx)
+		el.Free()
+	})
+	return addr
+}
+
+func TestNetListener_HTTP(t *testing.T) {
+	for _, taskType := range []TaskType{TaskTypeInEventLoop, TaskTypeInBusinessGoroutine, TaskTypeInConnectionGoroutine} {
+		ln := NewNetListener(nil)
+		addr := newNetConnServer(t, ln, taskType)
+
+		srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			w.Write([]byte("hello " + r.URL.Path + " " + string(body)))
+		})}
+		go srv.Serve(ln)
+
+		client := &http.Client{Timeout: 2 * time.Second}
+		for i := 0; i < 3; i++ {
+			resp, err := client.Post("http://"+addr.String()+"/pulse", "text/plain", strings.NewReader("body"))
+			if err != nil {
+				t.Fatalf("taskType=%d: Post() error = %v", taskType, err)
+			}
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil || string(body) != "hello /pulse body" {
+				t.Errorf("taskType=%d: body = %q, %v", taskType, body, err)
+			}
+		}
+		client.CloseIdleConnections()
+		srv.Close()
+	}
+}
+
+// selfSignedCert 测试用的自签名证书
+func selfSignedCert(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "pulse"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate() error = %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestNetConnCallback_TLS(t *testing.T) {
+	config := &tls.Config{Certificates: []tls.Certificate{selfSignedCert(t)}}
+	addr := newNetConnServer(t, NetConnCallback(func(c *Conn) {
+		defer c.Close()
+		tc := tls.Server(c, config)
+		r := bufio.NewReader(tc)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if _, err := tc.Write([]byte("tls:" + line)); err != nil {
+				return
+			}
+		}
+	}), TaskTypeInBusinessGoroutine)
+
+	c, err := tls.Dial("tcp", addr.String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("tls.Dial() error = %v", err)
+	}
+	defer c.Close()
+	_ = c.SetDeadline(time.Now().Add(2 * time.Second))
+	r := bufio.NewReader(c)
+	for _, msg := range []string{"hello\n", "world\n"} {
+		if _, err := c.Write([]byte(msg)); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		reply, err := r.ReadString('\n')
+		if err != nil || reply != "tls:"+msg {
+			t.Errorf("reply = %q, %v, want %q", reply, err, "tls:"+msg)
+		}
+	}
+}
+
+func TestConn_ReadDeadline_NetConn(t *testing.T) {
+	result := make(chan error, 2)
+	addr := newNetConnServer(t, NetConnCallback(func(c *Conn) {
+		defer c.Close()
+		// 读超时之后连接还可以继续使用
+		_ = c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		buf := make([]byte, 16)
+		_, err := c.Read(buf)
+		result <- err
+
+		_ = c.SetReadDeadline(time.Time{})
+		n, err := c.Read(buf)
+		if err == nil {
+			_, err = c.Write(buf[:n])
+		}
+		// 对端关闭之后读到EOF
+		_, eofErr := c.Read(buf)
+		result <- errors.Join(err, func() error {
+			if eofErr != io.EOF {
+				return eofErr
+			}
+			return nil
+		}())
+	}), TaskTypeInEventLoop)
+
+	c := dialRetry(t, "tcp", addr.String())
+	if err := <-result; !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Read() error = %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+	echoRoundTrip(t, c, "ping")
+	c.Close()
+	if err := <-result; err != nil {
+		t.Errorf("handler error = %v", err)
+	}
+}
+
+func TestConn_ReadNotSupported(t *testing.T) {
+	var c Conn
+	if _, err := c.Read(make([]byte, 1)); !errors.Is(err, errReadNotSupported) {
+		t.Errorf("Read() error = %v, want %v", err, errReadNotSupported)
+	}
+}
+
+// Read跟不上的时候读缓冲区不会无限增长, 读完之后恢复读, 数据不丢
+func TestNetConn_ReadBufferLimit(t *testing.T) {
+	const total = 32 << 20
+	for _, triggerType := range []core.TriggerType{core.TriggerTypeLevel, core.TriggerTypeEdge} {
+		for _, taskType := range []TaskType{TaskTypeInEventLoop, TaskTypeInBusinessGoroutine} {
+			conns := make(chan *Conn, 1)
+			release := make(chan struct{})
+			result := make(chan int64, 1)
+			el, err := NewMultiEventLoop(context.Background(),
+				WithCallback(NetConnCallback(func(c *Conn) {
+					defer c.Close()
+					conns <- c
+					<-release
+					n, _ := io.Copy(io.Discard, io.LimitReader(c, total))
+					// 暂停读的时候还可以写
+					_, _ = c.Write([]byte("done"))
+					result <- n
+				})),
+				WithTaskType(taskType),
+				WithTriggerType(triggerType))
+			if err != nil {
+				t.Fatalf("NewMultiEventLoop() error = %v", err)
+			}
+			addr, err := el.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("Listen() error = %v", err)
+			}
+
+			client := dialRetry(t, "tcp", addr.String())
+			go func() {
+				_, _ = client.Write(make([]byte, total))
+			}()
+			c := <-conns
+
+			// 等缓冲区涨到上限之后不再增长
+			var buffered int
+			for i := 0; i < 50; i++ {
+				time.Sleep(20 * time.Millisecond)
+				c.adapter.mu.Lock()
+				buffered = len(c.adapter.rbuf)
+				c.adapter.mu.Unlock()
+			}
+			if buffered > netConnMaxReadBuffer+64<<10 {
+				t.Errorf("trigger=%d taskType=%d: read buffer = %d, want <= %d", triggerType, taskType, buffered, netConnMaxReadBuffer)
+			}
+
+			close(release)
+			select {
+			case n := <-result:
+				if n != total {
+					t.Errorf("trigger=%d taskType=%d: read %d bytes, want %d", triggerType, taskType, n, total)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatalf("trigger=%d taskType=%d: read did not resume", triggerType, taskType)
+			}
+			buf := make([]byte, 4)
+			_ = client.SetReadDeadline(time.Now().Add(2 * time.Second))
+			if _, err := io.ReadFull(client, buf); err != nil || string(buf) != "done" {
+				t.Errorf("trigger=%d taskType=%d: reply = %q, %v", triggerType, taskType, buf, err)
+			}
+			client.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			_ = el.Shutdown(ctx)
+			cancel()
+			el.Free()
+		}
+	}
+}
+
+// addReadRecorder 记录AddRead注册的fd
+type addReadRecorder struct {
+	core.PollingApi
+	mu  sync.Mutex
+	fds []int
+}
+
+func (r *addReadRecorder) AddRead(fd int) error {
+	r.mu.Lock()
+	r.fds = append(r.fds, fd)
+	r.mu.Unlock()
+	return r.PollingApi.AddRead(fd)
+}
+
+// NetListener关闭之后OnOpen直接关闭新连接, 已经关闭的fd不能再注册到event loop
+func TestNetListener_ClosedConnNotRegistered(t *testing.T) {
+	ln := NewNetListener(nil)
+	ln.Close()
+	el, err := NewMultiEventLoop(context.Background(), WithCallback(ln), WithTaskType(TaskTypeInEventLoop))
+	if err != nil {
+		t.Fatalf("NewMultiEventLoop() error = %v", err)
+	}
+	defer el.Free()
+	recorders := make([]*addReadRecorder, len(el.eventLoops))
+	for i, eventLoop := range el.eventLoops {
+		recorders[i] = &addReadRecorder{PollingApi: eventLoop.PollingApi}
+		eventLoop.PollingApi = recorders[i]
+	}
+	addr, err := el.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+
+	c := dialRetry(t, "tcp", addr.String())
+	_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Read() error = %v, want EOF", err)
+	}
+	c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	_ = el.Shutdown(ctx)
+	cancel()
+	for _, r := range recorders {
+		r.mu.Lock()
+		if len(r.fds) != 0 {
+			t.Errorf("AddRead called for closed conns %v", r.fds)
+		}
+		r.mu.Unlock()
+	}
+}
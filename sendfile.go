@@ -0,0 +1,161 @@
+package pulse
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/antlabs/pulse/core"
+)
+
+// 单次sendfile最多发送的字节数, linux上限是0x7ffff000
+const maxSendFileChunk = 1 << 30
+
+// pendingFile SendFile还没有发送完的文件
+type pendingFile struct {
+	fd     int   // dup出来的文件描述符, 发送完或者连接关闭的时候关闭
+	offset int64 // 下一次发送的位置
+	remain int64 // 还剩多少字节
+	before int   // 写缓冲区里排在这个文件前面的字节数(从上一个文件结束的位置算起)
+}
+
+func (f *pendingFile) close() {
+	if err := core.Close(f.fd); err != nil {
+		slog.Error("failed to close file", "fd", f.fd, "error", err)
+	}
+}
+
+// SendFile 把文件f从offset开始的n个字节发送给对端, n <= 0的时候发送到文件末尾
+// linux/macOS上用sendfile, 数据不经过用户态也不进写缓冲区, 其他平台读出来再Write
+// 和Write/Writev的数据按调用顺序发送, 内核写不下的部分等可写事件继续发送
+// 内部复制了文件描述符, 返回之后就可以关闭f. 还没发送的文件不计入Buffered()
+func (c *Conn) SendFile(f *os.File, offset, n int64) (int64, error) {
+	if n <= 0 {
+		fi, err := f.Stat()
+		if err != nil {
+			return 0, err
+		}
+		if n = fi.Size() - offset; n <= 0 {
+			return 0, nil
+		}
+	}
+
+	if c.adapter == nil {
+		return c.sendFile(f, offset, n)
+	}
+
+	if c.adapter.writeTimeout() {
+		return 0, os.ErrDeadlineExceeded
+	}
+	written, err := c.sendFile(f, offset, n)
+	if err != nil {
+		return written, err
+	}
+	return written, c.adapter.waitFlushed(c)
+}
+
+func (c *Conn) sendFile(f *os.File, offset, n int64) (int64, error) {
+	if !core.SendFileSupported {
+		return c.copyFile(f, offset, n)
+	}
+
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	src := -1
+	if ctrlErr := rc.Control(func(fd uintptr) {
+		src, err = core.Dup(int(fd))
+	}); ctrlErr != nil {
+		return 0, ctrlErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	pf := &pendingFile{fd: src, offset: offset, remain: n}
+
+	c.mu.Lock()
+	defer c.unlock()
+
+	if atomic.LoadInt64(&c.fd) == -1 {
+		pf.close()
+		return 0, net.ErrClosed
+	}
+	if err := c.waitWriterNoLock(); err != nil {
+		pf.close()
+		return 0, err
+	}
+	if c.closeWrite || c.closing {
+		pf.close()
+		return 0, ErrWriteClosed
+	}
+
+	// 排在写缓冲区里已有数据的后面
+	pf.before = c.buffered
+	for _, prev := range c.sendFiles {
+		pf.before -= prev.before
+	}
+	c.sendFiles = append(c.sendFiles, pf)
+	if err := c.flushNoLock(); err != nil {
+		c.closeNoLock(err)
+		return 0, err
+	}
+	return n, nil
+}
+
+// sendFileNoLock 用sendfile发送文件, 需要持有c.mu
+// 返回true表示发送完了, false表示内核缓冲区满了需要等可写事件
+func (c *Conn) sendFileNoLock(f *pendingFile) (bool, error) {
+	for f.remain > 0 {
+		n, err := core.SendFile(c.getFd(), f.fd, f.offset, int(min(f.remain, maxSendFileChunk)))
+		if n > 0 {
+			f.offset += int64(n)
+			f.remain -= int64(n)
+			if c.idleTimeout > 0 {
+				c.lastActive = time.Now()
+			}
+		}
+		if err != nil {
+			if errors.Is(err, core.EINTR) {
+				continue
+			}
+			if errors.Is(err, core.EAGAIN) {
+				return false, nil
+			}
+			return false, err
+		}
+		// 文件比SendFile的时候短
+		if n == 0 {
+			return false, io.ErrUnexpectedEOF
+		}
+	}
+	f.close()
+	return true, nil
+}
+
+// copyFile 不支持sendfile的平台, 分段读出来再写
+func (c *Conn) copyFile(f *os.File, offset, n int64) (int64, error) {
+	buf := make([]byte, min(n, 64*1024))
+	r := io.NewSectionReader(f, offset, n)
+	var written int64
+	for written < n {
+		m, err := r.Read(buf)
+		if m > 0 {
+			if _, werr := c.write(buf[:m]); werr != nil {
+				return written, werr
+			}
+			written += int64(m)
+		}
+		if err == io.EOF {
+			return written, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
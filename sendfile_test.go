@@ -0,0 +1,92 @@
+package pulse
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/antlabs/pulse/core"
+)
+
+func TestConn_SendFile(t *testing.T) {
+	content := make([]byte, 4<<20)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	name := filepath.Join(t.TempDir(), "blob")
+	if err := os.WriteFile(name, content, 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	// 文件和Write的数据交替发送, 内核缓冲区很小, 文件要分多次在可写事件里发送
+	want := bytes.Join([][]byte{
+		[]byte("HEAD"), content, []byte("MID"), content[100 : 100+1<<20], []byte("TAIL"),
+	}, nil)
+	for _, triggerType := range []core.TriggerType{TriggerTypeLevel, TriggerTypeEdge} {
+		sendErr := make(chan error, 1)
+		el, err := NewMultiEventLoop(context.Background(),
+			WithCallback(ToCallback(func(c *Conn, err error) {
+			}, func(c *Conn, data []byte) {
+				sendErr <- func() error {
+					f, err := os.Open(name)
+					if err != nil {
+						return err
+					}
+					// SendFile返回之后就可以关闭文件
+					defer f.Close()
+					if _, err := c.Write([]byte("HEAD")); err != nil {
+						return err
+					}
+					if n, err := c.SendFile(f, 0, 0); err != nil || n != int64(len(content)) {
+						t.Errorf("SendFile() = %d, %v, want %d", n, err, len(content))
+					}
+					if _, err := c.Write([]byte("MID")); err != nil {
+						return err
+					}
+					if _, err := c.SendFile(f, 100, 1<<20); err != nil {
+						return err
+					}
+					_, err = c.Write([]byte("TAIL"))
+					return err
+				}()
+			}, func(c *Conn, err error) {})),
+			WithTaskType(TaskTypeInEventLoop),
+			WithTriggerType(triggerType),
+			WithSocketSendBuffer(64*1024))
+		if err != nil {
+			t.Fatalf("NewMultiEventLoop() error = %v", err)
+		}
+		addr, err := el.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Listen() error = %v", err)
+		}
+
+		c := dialRetry(t, "tcp", addr.String())
+		if _, err := c.Write([]byte("get")); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		if err := <-sendErr; err != nil {
+			t.Fatalf("send error = %v", err)
+		}
+		// 先不读, 让文件留在待发送队列里
+		time.Sleep(50 * time.Millisecond)
+		got := make([]byte, len(want))
+		_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := io.ReadFull(c, got); err != nil {
+			t.Fatalf("ReadFull() error = %v", err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("triggerType %v: received data does not match", triggerType)
+		}
+
+		c.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_ = el.Shutdown(ctx)
+		cancel()
+		el.Free()
+	}
+}
@@ -0,0 +1,70 @@
+//go:build !windows
+
+package pulse
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// systemd socket activation约定, 继承的fd从3开始
+// https://www.freedesktop.org/software/systemd/man/sd_listen_fds.html
+const listenFdsStart = 3
+
+// InheritedListeners 返回从父进程继承的监听socket
+// 1.HotRestart启动的新进程, 从旧进程那里接收监听socket
+// 2.systemd socket activation(LISTEN_PID, LISTEN_FDS, LISTEN_FDNAMES), 或者父进程通过ExtraFiles传下来的socket
+// 没有继承的socket时返回nil, nil. 读取之后会清除这几个环境变量, 避免再传给子进程
+// 返回的listener可以直接交给MultiEventLoop.Serve
+func InheritedListeners() ([]net.Listener, error) {
+	if os.Getenv(envRestartFd) != "" {
+		return restartListeners()
+	}
+
+	defer func() {
+		_ = os.Unsetenv("LISTEN_PID")
+		_ = os.Unsetenv("LISTEN_FDS")
+		_ = os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	return inheritedListeners(listenFdsStart)
+}
+
+func inheritedListeners(start int) ([]net.Listener, error) {
+	// LISTEN_PID不是当前进程，说明是传给其他进程的
+	if pid := os.Getenv("LISTEN_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+
+	nfds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || nfds <= 0 {
+		return nil, nil
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	listeners := make([]net.Listener, 0, nfds)
+	for fd := start; fd < start+nfds; fd++ {
+		syscall.CloseOnExec(fd)
+
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i := fd - start; i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		// FileListener会复制一份fd, 原来的fd需要关闭
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		_ = f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return nil, fmt.Errorf("pulse: inherited fd %d(%s) is not a listener: %w", fd, name, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
@@ -0,0 +1,105 @@
+//go:build !windows
+
+package pulse
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// dupListenerFds 把listener复制到连续的fd上, 模拟systemd传下来的fd, 返回第一个fd
+func dupListenerFds(t *testing.T, ls ...net.Listener) int {
+	t.Helper()
+	// 测试进程里不会用到这么大的fd
+	start := 200
+	for i, l := range ls {
+		f, err := l.(*net.TCPListener).File()
+		if err != nil {
+			t.Fatalf("File() error = %v", err)
+		}
+		if err := unix.Dup2(int(f.Fd()), start+i); err != nil {
+			t.Fatalf("Dup2() error = %v", err)
+		}
+		f.Close()
+		l.Close()
+	}
+	return start
+}
+
+func TestInheritedListeners(t *testing.T) {
+	l1, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	l2, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	addr1, addr2 := l1.Addr().String(), l2.Addr().String()
+	start := dupListenerFds(t, l1, l2)
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "2")
+	t.Setenv("LISTEN_FDNAMES", "http:admin")
+
+	listeners, err := inheritedListeners(start)
+	if err != nil {
+		t.Fatalf("inheritedListeners() error = %v", err)
+	}
+	if len(listeners) != 2 {
+		t.Fatalf("inheritedListeners() got %d listeners, want 2", len(listeners))
+	}
+	if listeners[0].Addr().String() != addr1 || listeners[1].Addr().String() != addr2 {
+		t.Errorf("listener addrs = %v %v, want %v %v", listeners[0].Addr(), listeners[1].Addr(), addr1, addr2)
+	}
+
+	el, err := NewMultiEventLoop(context.Background(), WithCallback(echoCallback{}), WithTaskType(TaskTypeInEventLoop))
+	if err != nil {
+		t.Fatalf("NewMultiEventLoop() error = %v", err)
+	}
+	defer el.Free()
+
+	serveErr := make(chan error, len(listeners))
+	for _, l := range listeners {
+		l := l
+		go func() {
+			serveErr <- el.Serve(l)
+		}()
+	}
+
+	for _, addr := range []string{addr1, addr2} {
+		c := dialRetry(t, "tcp", addr)
+		echoRoundTrip(t, c, "socket activation")
+		c.Close()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := el.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	for range listeners {
+		if err := <-serveErr; !errors.Is(err, ErrServerClosed) {
+			t.Errorf("Serve() error = %v, want %v", err, ErrServerClosed)
+		}
+	}
+}
+
+func TestInheritedListeners_NotForUs(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	listeners, err := InheritedListeners()
+	if err != nil || listeners != nil {
+		t.Errorf("InheritedListeners() = %v, %v, want nil, nil", listeners, err)
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Error("LISTEN_FDS should be unset")
+	}
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package pulse
+
+import "net"
+
+// InheritedListeners windows不支持socket activation
+func InheritedListeners() ([]net.Listener, error) {
+	return nil, nil
+}
@@ -0,0 +1,109 @@
+package pulse
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/antlabs/pulse/core"
+)
+
+// SetKeepAlive 打开或者关闭TCP keepalive
+func (c *Conn) SetKeepAlive(keepalive bool) error {
+	return core.SetKeepAlive(c.getFd(), keepalive)
+}
+
+// SetKeepAlivePeriod 打开TCP keepalive, 空闲d之后开始探测, 之后每隔d探测一次, 精度是秒
+// 和net.TCPConn一样, 探测次数使用系统默认值, 需要修改的时候用core.SetKeepAliveCount
+// windows上还不支持设置探测时间, 返回errors.ErrUnsupported
+func (c *Conn) SetKeepAlivePeriod(d time.Duration) error {
+	fd := c.getFd()
+	if err := core.SetKeepAlive(fd, true); err != nil {
+		return err
+	}
+	if err := core.SetKeepAliveIdle(fd, d); err != nil {
+		return err
+	}
+	return core.SetKeepAliveInterval(fd, d)
+}
+
+// SetReadBuffer 设置内核接收缓冲区大小(SO_RCVBUF)
+func (c *Conn) SetReadBuffer(bytes int) error {
+	return core.SetRecvBufferSize(c.getFd(), bytes)
+}
+
+// ReadBuffer 返回内核接收缓冲区大小, linux下是设置值的两倍
+func (c *Conn) ReadBuffer() (int, error) {
+	return core.GetRecvBufferSize(c.getFd())
+}
+
+// SetWriteBuffer 设置内核发送缓冲区大小(SO_SNDBUF)
+func (c *Conn) SetWriteBuffer(bytes int) error {
+	return core.SetSendBufferSize(c.getFd(), bytes)
+}
+
+// WriteBuffer 返回内核发送缓冲区大小, linux下是设置值的两倍
+func (c *Conn) WriteBuffer() (int, error) {
+	return core.GetSendBufferSize(c.getFd())
+}
+
+// SetLinger 和net.TCPConn.SetLinger一样, sec < 0是默认行为, sec == 0关闭的时候直接发RST
+// sec > 0会让close阻塞event loop, 不建议使用
+func (c *Conn) SetLinger(sec int) error {
+	return core.SetLinger(c.getFd(), sec)
+}
+
+// Linger 返回SO_LINGER的秒数, 没有打开的时候返回-1
+func (c *Conn) Linger() (int, error) {
+	return core.GetLinger(c.getFd())
+}
+
+// SetTCPUserTimeout 发出去的数据超过d没有被确认的时候内核关闭连接, 只支持linux
+func (c *Conn) SetTCPUserTimeout(d time.Duration) error {
+	return core.SetTCPUserTimeout(c.getFd(), d)
+}
+
+func (c *Conn) TCPUserTimeout() (time.Duration, error) {
+	return core.GetTCPUserTimeout(c.getFd())
+}
+
+// SetQuickAck 立即回复ack, 只支持linux, 内核会自动关闭, 需要的时候每次读完数据再设置
+func (c *Conn) SetQuickAck(quickack bool) error {
+	return core.SetQuickAck(c.getFd(), quickack)
+}
+
+func (c *Conn) QuickAck() (bool, error) {
+	return core.GetQuickAck(c.getFd())
+}
+
+// SetCork 攒满一个包再发送(linux TCP_CORK, bsd TCP_NOPUSH), 关闭的时候立即发出攒着的数据
+func (c *Conn) SetCork(cork bool) error {
+	return core.SetCork(c.getFd(), cork)
+}
+
+func (c *Conn) Cork() (bool, error) {
+	return core.GetCork(c.getFd())
+}
+
+// SetTOS 设置IP_TOS(ipv6是IPV6_TCLASS), 比如DSCP标记
+func (c *Conn) SetTOS(tos int) error {
+	return core.SetTOS(c.getFd(), tos)
+}
+
+func (c *Conn) TOS() (int, error) {
+	return core.GetTOS(c.getFd())
+}
+
+// sockopt 新连接默认设置的socket选项
+type sockopt struct {
+	name  string
+	apply func(fd int) error
+}
+
+// applySockopts 在OnOpen之前设置Options里的socket选项, 失败只打日志, 不影响连接
+func applySockopts(fd int, opts []sockopt) {
+	for _, opt := range opts {
+		if err := opt.apply(fd); err != nil {
+			slog.Warn("failed to set socket option", "fd", fd, "option", opt.name, "error", err)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+//go:build linux
+
+package pulse
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMultiEventLoop_SocketOptions(t *testing.T) {
+	type result struct {
+		linger      int
+		tos         int
+		userTimeout time.Duration
+		cork        bool
+		sndbuf      int
+	}
+	got := make(chan result, 1)
+	el, err := NewMultiEventLoop(context.Background(),
+		WithCallback(ToCallback(func(c *Conn, err error) {
+			var r result
+			r.linger, _ = c.Linger()
+			r.tos, _ = c.TOS()
+			r.userTimeout, _ = c.TCPUserTimeout()
+			r.cork, _ = c.Cork()
+			r.sndbuf, _ = c.WriteBuffer()
+			got <- r
+		}, func(c *Conn, data []byte) {}, func(c *Conn, err error) {})),
+		WithTaskType(TaskTypeInEventLoop),
+		WithLinger(0),
+		WithTOS(0x10),
+		WithTCPUserTimeout(5*time.Second),
+		WithCork(true),
+		WithSocketSendBuffer(64*1024))
+	if err != nil {
+		t.Fatalf("NewMultiEventLoop() error = %v", err)
+	}
+	defer el.Free()
+	addr, err := el.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = el.Shutdown(ctx)
+	}()
+
+	c := dialRetry(t, "tcp", addr.String())
+	defer c.Close()
+
+	select {
+	case r := <-got:
+		want := result{linger: 0, tos: 0x10, userTimeout: 5 * time.Second, cork: true, sndbuf: 128 * 1024}
+		if r != want {
+			t.Errorf("socket options = %+v, want %+v", r, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for OnOpen")
+	}
+}
@@ -0,0 +1,197 @@
+package pulse
+
+import (
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 时间轮的精度和槽数, 一圈是tick * wheelSlots
+const (
+	wheelTick  = 10 * time.Millisecond
+	wheelSlots = 512
+)
+
+// Timer 由event loop的时间轮驱动的定时器, 回调在event loop协程里执行
+// 不会占用runtime的定时器, 几十万个连接的超时也只是时间轮里的链表节点
+type Timer struct {
+	loop    *eventLoop
+	rt      *time.Timer // 没有event loop的连接(测试里直接构造的Conn)使用标准库的定时器
+	fn      func()
+	stopped atomic.Bool
+
+	// 下面的字段由时间轮的锁保护
+	when       int64 // 到期时间, UnixNano
+	rounds     int   // 还需要转几圈
+	slot       int
+	inWheel    bool
+	prev, next *Timer
+}
+
+// newTimer d之后在event loop里执行fn, loop为nil的时候在其他协程里执行
+func newTimer(loop *eventLoop, d time.Duration, fn func()) *Timer {
+	t := &Timer{loop: loop, fn: fn}
+	if loop == nil {
+		t.rt = time.AfterFunc(d, fn)
+		return t
+	}
+	t.schedule(d)
+	return t
+}
+
+// schedule 直接加到时间轮里, 不需要投递到event loop
+// 只有event loop没有超时地等在Poll里的时候才需要唤醒, 重新计算超时时间
+func (t *Timer) schedule(d time.Duration) {
+	now := time.Now().UnixNano()
+	t.stopped.Store(false)
+	if t.loop.wheel.add(t, now+int64(d), now) {
+		if err := t.loop.Wake(); err != nil {
+			slog.Error("wake event loop", "err", err)
+		}
+	}
+}
+
+// Stop 停止定时器, 在event loop协程里调用的时候保证fn不会再执行
+func (t *Timer) Stop() {
+	if t.rt != nil {
+		t.rt.Stop()
+		return
+	}
+	t.stopped.Store(true)
+	t.loop.wheel.remove(t)
+}
+
+// Reset 重新设置为d之后执行, 已经执行过或者被Stop的定时器也可以Reset
+func (t *Timer) Reset(d time.Duration) {
+	if t.rt != nil {
+		t.rt.Reset(d)
+		return
+	}
+	t.schedule(d)
+}
+
+// timingWheel 哈希时间轮, 由event loop推进, 其他协程可以直接添加和删除定时器
+// Poll的超时时间是到下一个tick的时间, Poll返回之后推进时间轮, 执行到期的定时器
+type timingWheel struct {
+	mu     sync.Mutex
+	slots  [wheelSlots]*Timer // 每个槽是一个双向链表
+	pos    int                // 当前槽
+	base   int64              // 当前槽对应的时间, UnixNano
+	count  int                // 定时器个数, 为0的时候Poll不需要超时
+	parked bool               // 时间轮是空的, event loop没有超时地等在Poll里
+	due    []*Timer           // advance的时候复用, 只在event loop协程里访问
+}
+
+// add 返回true表示需要唤醒event loop
+func (w *timingWheel) add(t *Timer, when, now int64) (needWake bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.removeLocked(t)
+	needWake, w.parked = w.parked, false
+	if w.count == 0 {
+		// 没有定时器的时候时间轮不走, 重新对齐当前时间
+		w.base = now
+	}
+
+	// 向上取整, 保证不会提前执行
+	ticks := int((when - w.base + int64(wheelTick) - 1) / int64(wheelTick))
+	if ticks < 1 {
+		ticks = 1
+	}
+	t.when = when
+	t.rounds = (ticks - 1) / wheelSlots
+	t.slot = (w.pos + ticks) % wheelSlots
+	t.prev = nil
+	t.next = w.slots[t.slot]
+	if t.next != nil {
+		t.next.prev = t
+	}
+	w.slots[t.slot] = t
+	t.inWheel = true
+	w.count++
+	return needWake
+}
+
+func (w *timingWheel) remove(t *Timer) {
+	w.mu.Lock()
+	w.removeLocked(t)
+	w.mu.Unlock()
+}
+
+func (w *timingWheel) removeLocked(t *Timer) {
+	if !t.inWheel {
+		return
+	}
+	if t.prev != nil {
+		t.prev.next = t.next
+	} else {
+		w.slots[t.slot] = t.next
+	}
+	if t.next != nil {
+		t.next.prev = t.prev
+	}
+	t.prev, t.next = nil, nil
+	t.inWheel = false
+	w.count--
+}
+
+// stop event loop退出之后不再需要唤醒
+func (w *timingWheel) stop() {
+	w.mu.Lock()
+	w.parked = false
+	w.mu.Unlock()
+}
+
+// timeout 下一次Poll的超时时间, 0表示一直等待, 之后加定时器的时候需要唤醒Poll
+func (w *timingWheel) timeout(now int64) time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.count == 0 {
+		w.parked = true
+		return 0
+	}
+	d := time.Duration(w.base + int64(wheelTick) - now)
+	// epoll的精度是毫秒, 不足1ms的时候会变成非阻塞
+	if d < time.Millisecond {
+		d = time.Millisecond
+	}
+	return d
+}
+
+// advance 推进到now, 执行到期的定时器, 只在event loop协程里调用
+// 执行fn的时候不持有锁, fn里可以Reset或者Stop定时器
+func (w *timingWheel) advance(now int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.parked = false
+	for w.count > 0 && w.base+int64(wheelTick) <= now {
+		w.base += int64(wheelTick)
+		w.pos = (w.pos + 1) % wheelSlots
+		// 先摘下到期的定时器再执行, fn里可能会删除同一个槽里的其他定时器
+		due := w.due[:0]
+		for t := w.slots[w.pos]; t != nil; {
+			next := t.next
+			if t.rounds > 0 {
+				t.rounds--
+			} else {
+				w.removeLocked(t)
+				due = append(due, t)
+			}
+			t = next
+		}
+		w.mu.Unlock()
+		for i, t := range due {
+			due[i] = nil
+			// 前面的fn里或者其他协程可能Reset了这个定时器
+			w.mu.Lock()
+			fire := !t.inWheel && !t.stopped.Load()
+			w.mu.Unlock()
+			if fire {
+				t.fn()
+			}
+		}
+		w.mu.Lock()
+		w.due = due[:0]
+	}
+}
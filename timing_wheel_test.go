@@ -0,0 +1,191 @@
+package pulse
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/antlabs/pulse/core"
+)
+
+func TestTimingWheel(t *testing.T) {
+	var w timingWheel
+	var fired []int
+	newWheelTimer := func(id int) *Timer {
+		return &Timer{fn: func() { fired = append(fired, id) }}
+	}
+
+	base := time.Now().UnixNano()
+	tick := int64(wheelTick)
+	t1 := newWheelTimer(1)
+	t2 := newWheelTimer(2)
+	t3 := newWheelTimer(3)
+	// 超过一圈的定时器
+	t4 := newWheelTimer(4)
+	w.add(t1, base+tick/2, base)
+	w.add(t2, base+3*tick, base)
+	w.add(t3, base+3*tick, base)
+	w.add(t4, base+(wheelSlots+5)*tick, base)
+	if w.count != 4 {
+		t.Fatalf("count = %d, want 4", w.count)
+	}
+	if d := w.timeout(base); d != wheelTick {
+		t.Errorf("timeout() = %v, want %v", d, wheelTick)
+	}
+
+	w.advance(base + tick)
+	if len(fired) != 1 || fired[0] != 1 {
+		t.Fatalf("fired = %v, want [1]", fired)
+	}
+
+	// 删除的定时器不会执行
+	w.remove(t3)
+	w.advance(base + 3*tick - 1)
+	if len(fired) != 1 {
+		t.Fatalf("fired too early: %v", fired)
+	}
+	w.advance(base + 3*tick)
+	if len(fired) != 2 || fired[1] != 2 {
+		t.Fatalf("fired = %v, want [1 2]", fired)
+	}
+
+	// 经过同一个槽的时候还没到期
+	w.advance(base + 5*tick)
+	if len(fired) != 2 {
+		t.Fatalf("timer with rounds fired too early: %v", fired)
+	}
+	w.advance(base + (wheelSlots+5)*tick)
+	if len(fired) != 3 || fired[2] != 4 {
+		t.Fatalf("fired = %v, want [1 2 4]", fired)
+	}
+	if w.count != 0 {
+		t.Errorf("count = %d, want 0", w.count)
+	}
+	if d := w.timeout(base); d != 0 {
+		t.Errorf("timeout() = %v, want 0 for empty wheel", d)
+	}
+}
+
+func TestConn_AfterFunc(t *testing.T) {
+	opened := make(chan *Conn, 1)
+	el, err := NewMultiEventLoop(context.Background(),
+		WithCallback(ToCallback(func(c *Conn, err error) {
+			opened <- c
+		}, func(c *Conn, data []byte) {}, func(c *Conn, err error) {})),
+		WithTaskType(TaskTypeInEventLoop),
+		WithEventLoopCount(1))
+	if err != nil {
+		t.Fatalf("NewMultiEventLoop() error = %v", err)
+	}
+	defer el.Free()
+	addr, err := el.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = el.Shutdown(ctx)
+	}()
+
+	client := dialRetry(t, "tcp", addr.String())
+	defer client.Close()
+	c := <-opened
+
+	done := make(chan time.Duration, 1)
+	start := time.Now()
+	c.AfterFunc(50*time.Millisecond, func() {
+		done <- time.Since(start)
+	})
+	var stopped atomic.Bool
+	timer := c.AfterFunc(30*time.Millisecond, func() {
+		stopped.Store(true)
+	})
+	timer.Stop()
+
+	select {
+	case d := <-done:
+		if d < 50*time.Millisecond {
+			t.Errorf("AfterFunc fired after %v, want >= 50ms", d)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("AfterFunc did not fire")
+	}
+	if stopped.Load() {
+		t.Error("stopped timer should not fire")
+	}
+
+	// 连接关闭之后不再执行
+	var afterClose atomic.Bool
+	c.AfterFunc(20*time.Millisecond, func() {
+		afterClose.Store(true)
+	})
+	c.Close()
+	time.Sleep(100 * time.Millisecond)
+	if afterClose.Load() {
+		t.Error("AfterFunc should not run after conn closed")
+	}
+}
+
+// wakeCounter 记录Wake的次数
+type wakeCounter struct {
+	core.PollingApi
+	n atomic.Int64
+}
+
+func (w *wakeCounter) Wake() error {
+	w.n.Add(1)
+	return w.PollingApi.Wake()
+}
+
+// event loop协程里的定时器直接加到时间轮里, 不需要投递任务和唤醒Poll
+func TestTimer_ScheduleOnLoopNoWake(t *testing.T) {
+	const rounds = 5
+	done := make(chan struct{})
+	el, err := NewMultiEventLoop(context.Background(),
+		WithCallback(ToCallback(func(c *Conn, err error) {}, func(c *Conn, data []byte) {
+			var n int
+			var rearm func()
+			rearm = func() {
+				if n++; n == rounds {
+					close(done)
+					return
+				}
+				c.AfterFunc(5*time.Millisecond, rearm)
+			}
+			c.AfterFunc(5*time.Millisecond, rearm)
+		}, func(c *Conn, err error) {})),
+		WithTaskType(TaskTypeInEventLoop),
+		WithEventLoopCount(1))
+	if err != nil {
+		t.Fatalf("NewMultiEventLoop() error = %v", err)
+	}
+	defer el.Free()
+	wakes := &wakeCounter{PollingApi: el.eventLoops[0].PollingApi}
+	el.eventLoops[0].PollingApi = wakes
+	addr, err := el.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = el.Shutdown(ctx)
+	}()
+
+	client := dialRetry(t, "tcp", addr.String())
+	defer client.Close()
+	if _, err := client.Write([]byte("x")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timers did not fire")
+	}
+	// 时间轮是空的时候第一个定时器最多唤醒一次, 之后Reset不再唤醒
+	if n := wakes.n.Load(); n > 1 {
+		t.Errorf("Wake called %d times, want <= 1", n)
+	}
+}
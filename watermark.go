@@ -0,0 +1,142 @@
+package pulse
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// Buffered 返回写缓冲区里还没有写入内核的字节数
+func (c *Conn) Buffered() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.buffered
+}
+
+// BufferFull 写缓冲区超过了高水位, 还没有降到低水位
+func (c *Conn) BufferFull() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bufferFull
+}
+
+// growBuffered 数据放进写缓冲区之后调用, 需要持有c.mu
+func (c *Conn) growBuffered(n int) {
+	c.buffered += n
+	if c.bufferFull || c.options == nil || c.options.highWatermark <= 0 {
+		return
+	}
+	if c.buffered >= c.options.highWatermark {
+		c.bufferFull = true
+		c.notifyFull = c.options.onBufferFull != nil
+	}
+}
+
+// shrinkBuffered 写缓冲区的数据写入内核之后调用, 需要持有c.mu
+func (c *Conn) shrinkBuffered(n int) {
+	c.buffered -= n
+	if c.pendingWaiters > 0 && c.buffered <= c.options.pendingResume() {
+		c.pendingCond.Broadcast()
+	}
+	if !c.bufferFull || c.buffered > c.options.lowWatermark {
+		return
+	}
+	c.bufferFull = false
+	// 同一次加锁里先满了又降下来, 两个回调都不需要
+	if c.notifyFull {
+		c.notifyFull = false
+		return
+	}
+	c.notifyWritable = c.options.onWritable != nil
+}
+
+// checkPendingNoLock 写缓冲区再放入n个字节会超过WithMaxPendingWriteBytes的时候, 按策略返回错误
+// PendingWriteBlock在queueNoLock里等待, 需要持有c.mu
+func (c *Conn) checkPendingNoLock(n int) error {
+	o := c.options
+	if o == nil || o.maxPendingWriteBytes <= 0 || c.buffered+n <= o.maxPendingWriteBytes {
+		return nil
+	}
+	switch o.pendingWritePolicy {
+	case PendingWriteClose:
+		c.closeNoLock(ErrWriteBufferFull)
+	case PendingWriteBlock:
+		// 任何一个event loop里阻塞都会卡住这个event loop上的所有连接, 两个event loop互相写的时候还会死锁, 只能返回错误
+		// Execute, AfterFunc, OnWritable等回调不管什么TaskType都在event loop协程里
+		if !onEventLoop() {
+			return nil
+		}
+	}
+	return ErrWriteBufferFull
+}
+
+// queueNoLock 把数据放到写缓冲区, 需要持有c.mu
+// PendingWriteBlock策略下超过上限的部分, 等写缓冲区降下来之后再放入
+// 等待的时候会释放c.mu, 用blockedWriter让其他Write排在后面, 每次Write的数据不会交错
+func (c *Conn) queueNoLock(data []byte) error {
+	limit := 0
+	if o := c.options; o != nil && o.pendingWritePolicy == PendingWriteBlock {
+		limit = o.maxPendingWriteBytes
+	}
+	if limit <= 0 {
+		c.appendToWbufList(data)
+		return nil
+	}
+
+	defer func() {
+		if c.blockedWriter {
+			c.blockedWriter = false
+			if c.pendingWaiters > 0 {
+				c.pendingCond.Broadcast()
+			}
+		}
+	}()
+	for len(data) > 0 {
+		// 等待的时候或者flush之后连接可能已经关闭了
+		if atomic.LoadInt64(&c.fd) == -1 {
+			return net.ErrClosed
+		}
+		room := limit - c.buffered
+		if room <= 0 {
+			c.blockedWriter = true
+			c.waitPendingNoLock()
+			continue
+		}
+
+		room = min(room, len(data))
+		c.appendToWbufList(data[:room])
+		data = data[room:]
+		if len(data) > 0 {
+			// 先交给内核, 再等写缓冲区降下来
+			if err := c.flushNoLock(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// waitWriterNoLock PendingWriteBlock策略下前面有Write在分段等待写缓冲区降下来, 排在它后面, 需要持有c.mu
+// event loop协程里不能等, 返回ErrWriteBufferFull
+func (c *Conn) waitWriterNoLock() error {
+	for c.blockedWriter {
+		if onEventLoop() {
+			return ErrWriteBufferFull
+		}
+		c.waitPendingNoLock()
+		if atomic.LoadInt64(&c.fd) == -1 {
+			return net.ErrClosed
+		}
+	}
+	return nil
+}
+
+// waitPendingNoLock 等写缓冲区降下来, 前面的Write写完或者连接关闭, 需要持有c.mu
+func (c *Conn) waitPendingNoLock() {
+	if c.pendingCond == nil {
+		c.pendingCond = sync.NewCond(&c.mu)
+	}
+	c.pendingWaiters++
+	c.pendingCond.Wait()
+	c.pendingWaiters--
+}
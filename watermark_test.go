@@ -0,0 +1,474 @@
+package pulse
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConn_WriteBufferWatermarks(t *testing.T) {
+	const (
+		high  = 256 * 1024
+		low   = 64 * 1024
+		chunk = 16 * 1024
+		total = 8 << 20
+	)
+	var full, writable atomic.Int32
+	resume := make(chan struct{}, 1)
+	produceErr := make(chan error, 1)
+	var paused bool // 只在生产者协程里访问, OnBufferFull也在生产者的Write里回调
+
+	el, err := NewMultiEventLoop(context.Background(),
+		WithCallback(ToCallback(func(c *Conn, err error) {
+		}, func(c *Conn, data []byte) {
+			// 模拟pub/sub的生产者, 写缓冲区满了就暂停, 可写了再继续
+			go func() {
+				payload := bytes.Repeat([]byte("p"), chunk)
+				for sent := 0; sent < total; sent += chunk {
+					if paused {
+						select {
+						case <-resume:
+						case <-time.After(5 * time.Second):
+							produceErr <- io.ErrNoProgress
+							return
+						}
+						paused = false
+					}
+					if _, err := c.Write(payload); err != nil {
+						produceErr <- err
+						return
+					}
+					if n := c.Buffered(); n > high+chunk {
+						t.Errorf("Buffered() = %d, want <= %d", n, high+chunk)
+					}
+				}
+				produceErr <- nil
+			}()
+		}, func(c *Conn, err error) {})),
+		WithTaskType(TaskTypeInEventLoop),
+		WithSocketSendBuffer(64*1024),
+		WithWriteBufferWatermarks(high, low),
+		WithOnBufferFull(func(c *Conn) {
+			full.Add(1)
+			paused = true
+		}),
+		WithOnWritable(func(c *Conn) {
+			writable.Add(1)
+			if n := c.Buffered(); n > low {
+				t.Errorf("Buffered() in OnWritable = %d, want <= %d", n, low)
+			}
+			resume <- struct{}{}
+		}))
+	if err != nil {
+		t.Fatalf("NewMultiEventLoop() error = %v", err)
+	}
+	defer el.Free()
+	addr, err := el.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = el.Shutdown(ctx)
+	}()
+
+	c := dialRetry(t, "tcp", addr.String())
+	defer c.Close()
+	if _, err := c.Write([]byte("sub")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	// 先不读, 让写缓冲区超过高水位
+	time.Sleep(50 * time.Millisecond)
+	_ = c.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := io.CopyN(io.Discard, c, total); err != nil {
+		t.Fatalf("CopyN() error = %v", err)
+	}
+	if err := <-produceErr; err != nil {
+		t.Fatalf("producer error = %v", err)
+	}
+	if full.Load() == 0 {
+		t.Error("OnBufferFull was not called")
+	}
+	if writable.Load() == 0 {
+		t.Error("OnWritable was not called")
+	}
+}
+
+func TestConn_MaxPendingWriteBytes(t *testing.T) {
+	const (
+		limit = 256 * 1024
+		chunk = 16 * 1024
+		total = 8 << 20
+	)
+	for _, tc := range []struct {
+		name     string
+		policy   PendingWritePolicy
+		taskType TaskType
+		wantErr  error // Write返回的错误, nil表示数据全部写完
+		closeErr error // OnClose收到的错误
+	}{
+		{name: "error", policy: PendingWriteError, taskType: TaskTypeInEventLoop, wantErr: ErrWriteBufferFull},
+		{name: "close", policy: PendingWriteClose, taskType: TaskTypeInEventLoop, wantErr: ErrWriteBufferFull, closeErr: ErrWriteBufferFull},
+		{name: "block", policy: PendingWriteBlock, taskType: TaskTypeInBusinessGoroutine},
+		{name: "block in event loop", policy: PendingWriteBlock, taskType: TaskTypeInEventLoop, wantErr: ErrWriteBufferFull},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			writeErr := make(chan error, 1)
+			closeErr := make(chan error, 1)
+			el, err := NewMultiEventLoop(context.Background(),
+				WithCallback(ToCallback(func(c *Conn, err error) {
+				}, func(c *Conn, data []byte) {
+					payload := bytes.Repeat([]byte("p"), chunk)
+					for sent := 0; sent < total; sent += chunk {
+						if _, err := c.Write(payload); err != nil {
+							writeErr <- err
+							return
+						}
+						if n := c.Buffered(); n > limit {
+							t.Errorf("Buffered() = %d, want <= %d", n, limit)
+						}
+					}
+					writeErr <- nil
+				}, func(c *Conn, err error) {
+					closeErr <- err
+				})),
+				WithTaskType(tc.taskType),
+				WithSocketSendBuffer(64*1024),
+				WithMaxPendingWriteBytes(limit, tc.policy))
+			if err != nil {
+				t.Fatalf("NewMultiEventLoop() error = %v", err)
+			}
+			defer el.Free()
+			addr, err := el.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("Listen() error = %v", err)
+			}
+			defer func() {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				defer cancel()
+				_ = el.Shutdown(ctx)
+			}()
+
+			c := dialRetry(t, "tcp", addr.String())
+			defer c.Close()
+			if _, err := c.Write([]byte("req")); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+
+			if tc.wantErr != nil {
+				// 客户端不读, 写缓冲区很快到上限
+				select {
+				case err := <-writeErr:
+					if err != tc.wantErr {
+						t.Errorf("Write() error = %v, want %v", err, tc.wantErr)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatal("timeout waiting for Write error")
+				}
+			} else {
+				// 等一会再读, 让Write先阻塞
+				time.Sleep(50 * time.Millisecond)
+				_ = c.SetReadDeadline(time.Now().Add(10 * time.Second))
+				if _, err := io.CopyN(io.Discard, c, total); err != nil {
+					t.Fatalf("CopyN() error = %v", err)
+				}
+				if err := <-writeErr; err != nil {
+					t.Errorf("Write() error = %v", err)
+				}
+			}
+
+			if tc.closeErr != nil {
+				select {
+				case err := <-closeErr:
+					if err != tc.closeErr {
+						t.Errorf("OnClose error = %v, want %v", err, tc.closeErr)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatal("timeout waiting for OnClose")
+				}
+			}
+		})
+	}
+}
+
+// event loop协程里的Write不管是什么TaskType都不能阻塞, Execute和OnWritable里超过上限直接返回错误
+func TestConn_MaxPendingWriteBytesBlockOnLoop(t *testing.T) {
+	const (
+		limit = 64 * 1024
+		high  = 32 * 1024
+		low   = 8 * 1024
+	)
+	big := make([]byte, 8<<20)
+	executeErr := make(chan error, 1)
+	writableErr := make(chan error, 1)
+	var writable atomic.Bool
+	el, err := NewMultiEventLoop(context.Background(),
+		WithCallback(ToCallback(func(c *Conn, err error) {
+		}, func(c *Conn, data []byte) {
+			_ = c.Execute(func() {
+				_, err := c.Write(big)
+				executeErr <- err
+				// 客户端还没读, 写到超过高水位, 读完之后在event loop里回调OnWritable
+				for !c.BufferFull() {
+					if _, err := c.Write(make([]byte, 16*1024)); err != nil {
+						break
+					}
+				}
+			})
+		}, func(c *Conn, err error) {})),
+		WithTaskType(TaskTypeInBusinessGoroutine),
+		WithSocketSendBuffer(4096),
+		WithWriteBufferWatermarks(high, low),
+		WithOnWritable(func(c *Conn) {
+			if writable.CompareAndSwap(false, true) {
+				_, err := c.Write(big)
+				writableErr <- err
+			}
+		}),
+		WithMaxPendingWriteBytes(limit, PendingWriteBlock))
+	if err != nil {
+		t.Fatalf("NewMultiEventLoop() error = %v", err)
+	}
+	defer el.Free()
+	addr, err := el.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = el.Shutdown(ctx)
+	}()
+
+	c := dialRetry(t, "tcp", addr.String())
+	defer c.Close()
+	if _, err := c.Write([]byte("req")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		errs chan error
+	}{
+		{name: "Execute", errs: executeErr},
+		{name: "OnWritable", errs: writableErr},
+	} {
+		select {
+		case err := <-tc.errs:
+			if err != ErrWriteBufferFull {
+				t.Errorf("%s: Write() error = %v, want %v", tc.name, err, ErrWriteBufferFull)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: Write blocked the event loop", tc.name)
+		}
+		if tc.name == "Execute" {
+			go func() { _, _ = io.Copy(io.Discard, c) }()
+		}
+	}
+}
+
+// 在一个event loop里写另一个event loop上的连接(比如广播), 超过上限也不能阻塞
+func TestConn_MaxPendingWriteBytesBlockCrossLoop(t *testing.T) {
+	const limit = 64 * 1024
+	var (
+		mu    sync.Mutex
+		conns []*Conn
+	)
+	writeErr := make(chan error, 1)
+	el, err := NewMultiEventLoop(context.Background(),
+		WithCallback(ToCallback(func(c *Conn, err error) {
+			mu.Lock()
+			conns = append(conns, c)
+			mu.Unlock()
+		}, func(c *Conn, data []byte) {
+			mu.Lock()
+			other := conns[1]
+			mu.Unlock()
+			if other.eventLoop == c.eventLoop {
+				writeErr <- errors.New("conns on the same event loop")
+				return
+			}
+			payload := make([]byte, 32*1024)
+			for {
+				if _, err := other.Write(payload); err != nil {
+					writeErr <- err
+					return
+				}
+			}
+		}, func(c *Conn, err error) {})),
+		WithTaskType(TaskTypeInEventLoop),
+		WithEventLoopCount(2),
+		WithLoadBalancer(RoundRobin()),
+		WithSocketSendBuffer(4096),
+		WithMaxPendingWriteBytes(limit, PendingWriteBlock))
+	if err != nil {
+		t.Fatalf("NewMultiEventLoop() error = %v", err)
+	}
+	defer el.Free()
+	addr, err := el.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = el.Shutdown(ctx)
+	}()
+
+	a := dialRetry(t, "tcp", addr.String())
+	defer a.Close()
+	// b的对端不读, 写缓冲区会到上限
+	b := dialRetry(t, "tcp", addr.String())
+	defer b.Close()
+	for i := 0; ; i++ {
+		mu.Lock()
+		n := len(conns)
+		mu.Unlock()
+		if n == 2 {
+			break
+		}
+		if i == 200 {
+			t.Fatal("timeout waiting for conns")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := a.Write([]byte("x")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	select {
+	case err := <-writeErr:
+		if err != ErrWriteBufferFull {
+			t.Errorf("Write() error = %v, want %v", err, ErrWriteBufferFull)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Write blocked the event loop")
+	}
+	// a所在的event loop还在工作
+	if _, err := a.Write([]byte("y")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+}
+
+// 超过上限的Write直接拒绝, 对端收不到这次Write的任何数据, 连接可以继续使用
+func TestConn_MaxPendingWriteBytesNoPartialWrite(t *testing.T) {
+	const limit = 64 * 1024
+	big := bytes.Repeat([]byte("b"), 1<<20)
+	writeErr := make(chan error, 2)
+	el, err := NewMultiEventLoop(context.Background(),
+		WithCallback(ToCallback(func(c *Conn, err error) {
+		}, func(c *Conn, data []byte) {
+			_, err := c.Write(big)
+			writeErr <- err
+			_, err = c.Writev([][]byte{[]byte("head"), big})
+			writeErr <- err
+			_, _ = c.Write([]byte("tail"))
+		}, func(c *Conn, err error) {})),
+		WithTaskType(TaskTypeInEventLoop),
+		WithMaxPendingWriteBytes(limit, PendingWriteError))
+	if err != nil {
+		t.Fatalf("NewMultiEventLoop() error = %v", err)
+	}
+	defer el.Free()
+	addr, err := el.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = el.Shutdown(ctx)
+	}()
+
+	c := dialRetry(t, "tcp", addr.String())
+	defer c.Close()
+	if _, err := c.Write([]byte("req")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-writeErr; err != ErrWriteBufferFull {
+			t.Errorf("Write() error = %v, want %v", err, ErrWriteBufferFull)
+		}
+	}
+
+	buf := make([]byte, 4)
+	_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "tail" {
+		t.Fatalf("peer received %q, %v, want tail", buf, err)
+	}
+	_ = c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if n, _ := c.Read(buf); n != 0 {
+		t.Errorf("peer received %d unexpected bytes", n)
+	}
+}
+
+// PendingWriteBlock策略下多个协程同时Write, 每次Write的数据是连续的
+func TestConn_MaxPendingWriteBytesBlockOrdering(t *testing.T) {
+	const (
+		limit   = 64 * 1024
+		size    = 512 * 1024
+		writers = 4
+	)
+	writeErr := make(chan error, writers)
+	el, err := NewMultiEventLoop(context.Background(),
+		WithCallback(ToCallback(func(c *Conn, err error) {
+		}, func(c *Conn, data []byte) {
+			for i := 0; i < writers; i++ {
+				payload := bytes.Repeat([]byte{byte('a' + i)}, size)
+				go func() {
+					_, err := c.Write(payload)
+					writeErr <- err
+				}()
+			}
+		}, func(c *Conn, err error) {})),
+		WithTaskType(TaskTypeInBusinessGoroutine),
+		WithSocketSendBuffer(4096),
+		WithMaxPendingWriteBytes(limit, PendingWriteBlock))
+	if err != nil {
+		t.Fatalf("NewMultiEventLoop() error = %v", err)
+	}
+	defer el.Free()
+	addr, err := el.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = el.Shutdown(ctx)
+	}()
+
+	c := dialRetry(t, "tcp", addr.String())
+	defer c.Close()
+	if _, err := c.Write([]byte("req")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	// 等一会再读, 让Write先阻塞
+	time.Sleep(50 * time.Millisecond)
+	got := make([]byte, writers*size)
+	_ = c.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := io.ReadFull(c, got); err != nil {
+		t.Fatalf("ReadFull() error = %v", err)
+	}
+	for i := 0; i < writers; i++ {
+		if err := <-writeErr; err != nil {
+			t.Errorf("Write() error = %v", err)
+		}
+	}
+
+	seen := make(map[byte]bool)
+	for off := 0; off < len(got); off += size {
+		chunk := got[off : off+size]
+		if seen[chunk[0]] || !bytes.Equal(chunk, bytes.Repeat(chunk[:1], size)) {
+			t.Fatalf("data of concurrent writes interleaved at offset %d", off)
+		}
+		seen[chunk[0]] = true
+	}
+}